@@ -11,6 +11,20 @@ specifying a container engine's PID. The PID information then can be used
 downstream in tools like [lxkns] to translate container PIDs between different
 PID namespaces.
 
+When the Podman engine runs inside another (system) container, use [NewForPID]
+instead, passing the PID of a process inside that container. The watcher then
+reaches the Podman API socket inside the container's mount namespace.
+
+Use [NewLazy] in order to create a watcher while the Podman API service isn't
+available yet, such as during boot; the watcher then connects only when it
+starts watching, with its backoff handling the wait for the service. Pass the
+[podman.WithSocketWaiting] option to instead wait for the service's socket to
+appear, so that the watcher becomes active as soon as the service appears.
+
+Podman engines nested inside watched containers, such as in Podman-in-Podman
+CI runners, can be discovered and watched too, using a
+[podman.NestedDiscovery] on top of a watcher.
+
 # Notes
 
 This package adds the following Podman-specific "annotation" labels to the
@@ -21,8 +35,50 @@ discovered containers:
   - io.github.thediveo/podman/infra ([InfraLabelName]) – just the presence of
     this label marks a container as an “infrastructure” container, its value
     doesn't matter and must not be relied upon.
+  - io.github.thediveo/podman/infraid ([InfraIDLabelName]),
+    io.github.thediveo/podman/infrapid ([InfraPIDLabelName]), and
+    io.github.thediveo/podman/infranetns ([InfraNetNSLabelName]) – only when
+    folding infra containers into their pod members: the ID, PID, and network
+    namespace path of the infra container of the pod a container belongs to.
+  - io.github.thediveo/podman/state ([StateLabelName]) – only in full
+    inventory mode: the container's state, such as "created", "running",
+    "paused", "exited", or "stopped".
+  - io.github.thediveo/podman/restored ([RestoredLabelName]) – only for
+    containers restored from a checkpoint: the time of the restore, in RFC
+    3339 format.
+  - io.github.thediveo/podman/checkpointorigin ([CheckpointOriginLabelName]) –
+    only for containers restored from a checkpoint, if known: the ID of the
+    checkpointed container, which differs from the restored container's ID
+    when restoring an exported checkpoint using "--import".
+  - io.github.thediveo/podman/uidmap ([UIDMapLabelName]) and
+    io.github.thediveo/podman/gidmap ([GIDMapLabelName]) – only when enabled
+    and only for containers in their own user namespaces: the UID and GID
+    mappings as comma-separated lists of "container:host:size" ID ranges. Use
+    [podman.ContainerIDMappings] to get the mappings in structured form.
+  - io.github.thediveo/podman/imagename ([ImageNameLabelName]),
+    io.github.thediveo/podman/imageid ([ImageIDLabelName]),
+    io.github.thediveo/podman/imagedigest ([ImageDigestLabelName]), and
+    io.github.thediveo/podman/imageplatform ([ImagePlatformLabelName]) – only
+    when enabled: the reference name, ID, repo digest (in "repo@digest" form),
+    and os/arch platform of the image a container was created from.
+  - io.github.thediveo/podman/enginepid ([EnginePIDLabelName]) – only when
+    translating PIDs into the caller's PID namespace: the container's PID in
+    the PID namespace of the Podman engine.
+  - io.github.thediveo/podman/pidinvisible ([PIDInvisibleLabelName]) – only
+    when translating PIDs: just the presence of this label marks a container
+    whose processes aren't visible from the caller's PID namespace, so its PID
+    is meaningless to the caller.
+  - io.github.thediveo/podman/parentcontainer ([ParentContainerLabelName]) –
+    only for containers of nested Podman engines: the ID of the container the
+    nested engine runs in.
+  - io.github.thediveo/podman/bundle ([BundleLabelName]) – only for
+    containers discovered from their conmon processes instead of via the
+    Podman API: the path of the container's OCI bundle.
 
 [Podman]: https://podman.io
+[podman.ContainerIDMappings]: https://pkg.go.dev/github.com/thediveo/sealwatcher/v2/podman#ContainerIDMappings
+[podman.WithSocketWaiting]: https://pkg.go.dev/github.com/thediveo/sealwatcher/v2/podman#WithSocketWaiting
+[podman.NestedDiscovery]: https://pkg.go.dev/github.com/thediveo/sealwatcher/v2/podman#NestedDiscovery
 [lxkns]: https://github.com/thediveo/lxkns
 */
 package sealwatcher
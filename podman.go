@@ -20,6 +20,7 @@ import (
 	"github.com/cenkalti/backoff/v4"
 	"github.com/containers/podman/v4/pkg/bindings"
 	engineclient "github.com/thediveo/sealwatcher/v2/podman"
+	"github.com/thediveo/sealwatcher/v2/util"
 	"github.com/thediveo/whalewatcher/watcher"
 )
 
@@ -34,6 +35,59 @@ const PodLabelName = engineclient.PodLabelName
 // containers only; the label value is irrelevant and must not be relied upon.
 const InfraLabelName = engineclient.InfraLabelName
 
+// UIDMapLabelName is the label key for the UID mappings of containers running
+// in their own user namespaces, when enabled using
+// [engineclient.WithIDMappings].
+const UIDMapLabelName = engineclient.UIDMapLabelName
+
+// GIDMapLabelName is the label key for the GID mappings of containers running
+// in their own user namespaces, when enabled using
+// [engineclient.WithIDMappings].
+const GIDMapLabelName = engineclient.GIDMapLabelName
+
+// Infra container label keys for pod member containers, when enabled using
+// [engineclient.WithInfraMode] with [engineclient.InfraFold].
+const (
+	InfraIDLabelName    = engineclient.InfraIDLabelName
+	InfraPIDLabelName   = engineclient.InfraPIDLabelName
+	InfraNetNSLabelName = engineclient.InfraNetNSLabelName
+)
+
+// StateLabelName is the label key for the container state, such as "running"
+// or "exited", when enabled using [engineclient.WithFullInventory].
+const StateLabelName = engineclient.StateLabelName
+
+// Checkpoint/restore label keys for containers restored from checkpoints.
+const (
+	RestoredLabelName         = engineclient.RestoredLabelName
+	CheckpointOriginLabelName = engineclient.CheckpointOriginLabelName
+)
+
+// BundleLabelName is the label key for the OCI bundle path of containers
+// discovered using [engineclient.ConmonWatcher].
+const BundleLabelName = engineclient.BundleLabelName
+
+// PID translation label keys, when enabled using
+// [engineclient.WithPIDTranslation].
+const (
+	EnginePIDLabelName    = engineclient.EnginePIDLabelName
+	PIDInvisibleLabelName = engineclient.PIDInvisibleLabelName
+)
+
+// ParentContainerLabelName is the label key for the ID of the container a
+// nested Podman engine runs in, for containers of nested engines discovered
+// using [engineclient.NestedDiscovery].
+const ParentContainerLabelName = engineclient.ParentContainerLabelName
+
+// Image identity label keys, when enabled using
+// [engineclient.WithImageAnnotations].
+const (
+	ImageNameLabelName     = engineclient.ImageNameLabelName
+	ImageIDLabelName       = engineclient.ImageIDLabelName
+	ImageDigestLabelName   = engineclient.ImageDigestLabelName
+	ImagePlatformLabelName = engineclient.ImagePlatformLabelName
+)
+
 // New returns a [watcher.Watcher] for keeping track of the currently alive
 // containers, optionally with the composer projects they're associated with.
 //
@@ -48,7 +102,46 @@ const InfraLabelName = engineclient.InfraLabelName
 func New(podmansock string, buggeroff backoff.BackOff, opts ...engineclient.NewOption) (watcher.Watcher, error) {
 	conn, err := bindings.NewConnection(context.Background(), podmansock)
 	if err != nil {
-		return nil, err
+		return nil, util.Classify(err)
 	}
 	return watcher.New(engineclient.NewPodmanWatcher(conn, opts...), buggeroff), nil
 }
+
+// NewForPID returns a [watcher.Watcher] for keeping track of the currently
+// alive containers of a Podman engine whose API socket is located in the mount
+// namespace of the process with the specified PID, such as when Podman runs
+// inside another (system) container. The podmansock parameter specifies the
+// socket as seen from inside that mount namespace; when left empty, it
+// defaults to "unix:///run/podman/podman.sock". See also
+// [engineclient.NewConnectionForPID] for how the socket is reached.
+//
+// The PID is also used as the engine's PID, unless overridden using
+// [engineclient.WithPID]; it thus should be the PID of the engine or another
+// process in the engine's PID namespace, so that
+// [engineclient.WithPIDTranslation] correctly translates container PIDs from
+// a nested engine's PID namespace.
+//
+// If the backoff is nil then the backoff defaults to backoff.StopBackOff, that
+// is, any failed operation will never be retried.
+func NewForPID(pid int, podmansock string, buggeroff backoff.BackOff, opts ...engineclient.NewOption) (watcher.Watcher, error) {
+	conn, err := engineclient.NewConnectionForPID(context.Background(), pid, podmansock)
+	if err != nil {
+		return nil, util.Classify(err)
+	}
+	opts = append([]engineclient.NewOption{engineclient.WithPID(pid)}, opts...)
+	return watcher.New(engineclient.NewPodmanWatcher(conn, opts...), buggeroff), nil
+}
+
+// NewLazy returns a [watcher.Watcher] like [New] does, but defers connecting
+// to the Podman API service until the watcher starts watching. NewLazy thus
+// succeeds even if the Podman API service isn't available yet, such as during
+// boot or when podman.socket isn't active; the watcher's backoff then handles
+// waiting for the service. See also [engineclient.WithLazyConnection] and
+// [engineclient.WithSocketWaiting].
+//
+// If the backoff is nil then the backoff defaults to backoff.StopBackOff, that
+// is, any failed operation will never be retried.
+func NewLazy(podmansock string, buggeroff backoff.BackOff, opts ...engineclient.NewOption) watcher.Watcher {
+	opts = append([]engineclient.NewOption{engineclient.WithLazyConnection(podmansock)}, opts...)
+	return watcher.New(engineclient.NewPodmanWatcher(nil, opts...), buggeroff)
+}
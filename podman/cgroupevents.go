@@ -0,0 +1,396 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/thediveo/whalewatcher/engineclient"
+)
+
+// DefaultCgroupRoot is the default mount point of the cgroup v2 unified
+// hierarchy.
+const DefaultCgroupRoot = "/sys/fs/cgroup"
+
+// cgroupConfirmationGrace is the time Podman has to emit an event confirming
+// a change that has already been observed in a container's cgroup.
+const cgroupConfirmationGrace = 30 * time.Second
+
+// CgroupDiagnostic describes a discrepancy between Podman's event stream and
+// the state of a container's cgroup, see [WithCgroupEvents].
+type CgroupDiagnostic struct {
+	ContainerID string
+	Event       string // Podman event, such as "died", "pause", or "unpause".
+	Message     string // description of the discrepancy.
+}
+
+// WithCgroupEvents cross-checks Podman's event stream with the
+// “cgroup.events” files of the cgroups of alive containers, in the cgroup v2
+// hierarchy mounted at cgroupRoot. If cgroupRoot is empty,
+// [DefaultCgroupRoot] is used.
+//
+// When a container's cgroup becomes unpopulated, an early ContainerExited
+// lifecycle event is emitted (except in full inventory mode, see
+// [WithFullInventory]). Similarly, when a container's cgroup gets frozen or
+// thawed, an early ContainerPaused or ContainerUnpaused lifecycle event is
+// emitted. The later Podman events then get swallowed. Any discrepancies, such
+// as a Podman “died” event for a container with a still populated cgroup, or
+// a missing Podman event, are reported to the specified diagnose function, if
+// not nil.
+//
+// Watching cgroup events works only for local Podman engines; otherwise, it is
+// silently disabled. It has no effect when polling the container list because
+// Podman doesn't emit any events.
+func WithCgroupEvents(cgroupRoot string, diagnose func(CgroupDiagnostic)) NewOption {
+	return func(pw *PodmanWatcher) {
+		if cgroupRoot == "" {
+			cgroupRoot = DefaultCgroupRoot
+		}
+		pw.cgroups = newCgroupMonitor(cgroupRoot, diagnose)
+	}
+}
+
+// cgroupEvents returns true if cgroup events watching is enabled and
+// applicable.
+func (pw *PodmanWatcher) cgroupEvents() bool {
+	return pw.cgroups != nil && pw.isLocal()
+}
+
+// cgroupLifecycleEvents returns the channel receiving the lifecycle events
+// derived from cgroup events, or nil if cgroup events watching isn't enabled.
+func (pw *PodmanWatcher) cgroupLifecycleEvents() <-chan engineclient.ContainerEvent {
+	if !pw.cgroupEvents() {
+		return nil
+	}
+	return pw.cgroups.events
+}
+
+// confirmCgroupEvent returns true if the specified Podman event confirms a
+// change already observed in the container's cgroup, so that the Podman event
+// needs to be swallowed.
+func (pw *PodmanWatcher) confirmCgroupEvent(id string, event string) bool {
+	if !pw.cgroupEvents() {
+		return false
+	}
+	return pw.cgroups.confirm(id, event)
+}
+
+// cgroupMonitor watches the cgroup.events files of container cgroups.
+type cgroupMonitor struct {
+	root     string
+	diagnose func(CgroupDiagnostic)
+	events   chan engineclient.ContainerEvent
+	done     chan struct{}
+
+	mu      sync.Mutex
+	watcher *fsnotify.Watcher                 // created lazily when watching the first cgroup.
+	cntrs   map[string]*cgroupState           // container ID -> cgroup state
+	files   map[string]*cgroupState           // cgroup.events path -> cgroup state
+	pending map[string]map[string]*time.Timer // container ID -> Podman events yet to come
+	exits   bool                              // emit early exit events.
+	closed  bool
+}
+
+// cgroupState is the last observed state of a container's cgroup.
+type cgroupState struct {
+	id        string
+	path      string // path of the cgroup.events file.
+	populated bool
+	frozen    bool
+}
+
+// newCgroupMonitor returns a new cgroup monitor for the cgroup hierarchy
+// mounted at root.
+func newCgroupMonitor(root string, diagnose func(CgroupDiagnostic)) *cgroupMonitor {
+	return &cgroupMonitor{
+		root:     root,
+		diagnose: diagnose,
+		events:   make(chan engineclient.ContainerEvent, 16),
+		done:     make(chan struct{}),
+		cntrs:    map[string]*cgroupState{},
+		files:    map[string]*cgroupState{},
+		pending:  map[string]map[string]*time.Timer{},
+		exits:    true,
+	}
+}
+
+// watch the cgroup with the specified path (relative to the cgroup root) of
+// the container with the specified ID.
+func (m *cgroupMonitor) watch(id string, cgrouppath string) {
+	if cgrouppath == "" {
+		return
+	}
+	path := filepath.Join(m.root, cgrouppath, "cgroup.events")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return
+	}
+	if state, ok := m.cntrs[id]; ok {
+		if state.path == path && m.files[path] == state {
+			return // already watching.
+		}
+		m.unwatchUnderLock(state)
+	}
+	populated, frozen, err := readCgroupEvents(path)
+	if err != nil || !populated {
+		return
+	}
+	if m.watcher == nil {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			return
+		}
+		m.watcher = watcher
+		go m.run(watcher)
+	}
+	if m.watcher.Add(path) != nil {
+		return
+	}
+	state := &cgroupState{
+		id:        id,
+		path:      path,
+		populated: populated,
+		frozen:    frozen,
+	}
+	m.cntrs[id] = state
+	m.files[path] = state
+}
+
+// unwatchUnderLock stops watching the cgroup of the specified state. It must
+// be called with the lock held.
+func (m *cgroupMonitor) unwatchUnderLock(state *cgroupState) {
+	_ = m.watcher.Remove(state.path)
+	delete(m.cntrs, state.id)
+	delete(m.files, state.path)
+}
+
+// run processes the inotify events for the watched cgroup.events files until
+// the watcher gets closed.
+func (m *cgroupMonitor) run(watcher *fsnotify.Watcher) {
+	for {
+		select {
+		case fsev, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			m.update(fsev)
+		case _, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+		}
+	}
+}
+
+// update the state of the cgroup whose cgroup.events file has changed,
+// emitting early lifecycle events for any changes.
+func (m *cgroupMonitor) update(fsev fsnotify.Event) {
+	m.mu.Lock()
+	state, ok := m.files[fsev.Name]
+	if !ok || m.closed {
+		m.mu.Unlock()
+		return
+	}
+	populated, frozen, err := readCgroupEvents(state.path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			m.mu.Unlock()
+			return
+		}
+		// The cgroup has been removed, so the container is gone.
+		populated, frozen = false, false
+	}
+	var evs []engineclient.ContainerEvent
+	if frozen != state.frozen && populated {
+		state.frozen = frozen
+		evtype, event := engineclient.ContainerUnpaused, "unpause"
+		if frozen {
+			evtype, event = engineclient.ContainerPaused, "pause"
+		}
+		evs = append(evs, engineclient.ContainerEvent{Type: evtype, ID: state.id})
+		m.expectUnderLock(state.id, event)
+	}
+	if !populated {
+		m.unwatchUnderLock(state)
+		if m.exits {
+			evs = append(evs, engineclient.ContainerEvent{
+				Type: engineclient.ContainerExited, ID: state.id})
+			m.expectUnderLock(state.id, "died")
+		}
+	}
+	m.mu.Unlock()
+	for _, ev := range evs {
+		select {
+		case m.events <- ev:
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// expectUnderLock records that the specified Podman event is yet to come for
+// the container with the specified ID, diagnosing when the event doesn't come
+// within the grace period. It must be called with the lock held.
+//
+// Expectations are kept separate from the cgroup states, so that they survive
+// watching the cgroup of a restarted container with the same ID before the
+// Podman “died” event of its previous run has arrived.
+func (m *cgroupMonitor) expectUnderLock(id string, event string) {
+	events, ok := m.pending[id]
+	if !ok {
+		events = map[string]*time.Timer{}
+		m.pending[id] = events
+	}
+	if timer, ok := events[event]; ok {
+		timer.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(cgroupConfirmationGrace, func() {
+		m.mu.Lock()
+		missing := m.pending[id][event] == timer
+		if missing {
+			m.forgetUnderLock(id, event)
+		}
+		closed := m.closed
+		m.mu.Unlock()
+		if missing && !closed {
+			m.report(id, event, "no Podman event confirming the cgroup change")
+		}
+	})
+	events[event] = timer
+}
+
+// forgetUnderLock removes the expectation of the specified Podman event for
+// the container with the specified ID. It must be called with the lock held.
+func (m *cgroupMonitor) forgetUnderLock(id string, event string) {
+	events := m.pending[id]
+	delete(events, event)
+	if len(events) == 0 {
+		delete(m.pending, id)
+	}
+}
+
+// confirm returns true if the specified Podman event for the container with
+// the specified ID was expected due to an earlier cgroup change. Otherwise,
+// the event gets cross-checked with the current cgroup state, reporting any
+// discrepancy.
+func (m *cgroupMonitor) confirm(id string, event string) bool {
+	m.mu.Lock()
+	if timer, pending := m.pending[id][event]; pending {
+		timer.Stop()
+		m.forgetUnderLock(id, event)
+		m.mu.Unlock()
+		return true
+	}
+	state, ok := m.cntrs[id]
+	if !ok {
+		m.mu.Unlock()
+		return false
+	}
+	if event == "died" {
+		m.unwatchUnderLock(state)
+	}
+	m.mu.Unlock()
+
+	populated, frozen, err := readCgroupEvents(state.path)
+	switch {
+	case err != nil:
+		// cgroup has gone, which is fine for "died", but otherwise we simply
+		// don't know.
+	case event == "died" && populated:
+		m.report(id, event, "container cgroup still populated")
+	case event == "pause" && !frozen:
+		m.report(id, event, "container cgroup not frozen")
+	case event == "unpause" && frozen:
+		m.report(id, event, "container cgroup still frozen")
+	}
+	return false
+}
+
+// expects returns true if the specified Podman event for the container with
+// the specified ID is yet to come, confirming an earlier cgroup change.
+func (m *cgroupMonitor) expects(id string, event string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, pending := m.pending[id][event]
+	return pending
+}
+
+// report the specified discrepancy, if there is a diagnose function.
+func (m *cgroupMonitor) report(id string, event string, msg string) {
+	if m.diagnose == nil {
+		return
+	}
+	m.diagnose(CgroupDiagnostic{ContainerID: id, Event: event, Message: msg})
+}
+
+// close the monitor, stopping watching all cgroups.
+func (m *cgroupMonitor) close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return
+	}
+	m.closed = true
+	close(m.done)
+	for _, events := range m.pending {
+		for _, timer := range events {
+			timer.Stop()
+		}
+	}
+	if m.watcher != nil {
+		m.watcher.Close()
+	}
+}
+
+// readCgroupEvents reads the “populated” and “frozen” keys from the specified
+// cgroup.events file. It returns an error if the “populated” key is missing.
+func readCgroupEvents(path string) (populated bool, frozen bool, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, false, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	haspopulated := false
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "populated":
+			populated = value == "1"
+			haspopulated = true
+		case "frozen":
+			frozen = value == "1"
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, false, err
+	}
+	if !haspopulated {
+		return false, false, errors.New("incomplete cgroup.events")
+	}
+	return populated, frozen, nil
+}
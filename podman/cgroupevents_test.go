@@ -0,0 +1,167 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/thediveo/whalewatcher/engineclient"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/thediveo/success"
+)
+
+// writeCgroupEvents writes a cgroup.events file for the specified cgroup in
+// the fake cgroupfs at root.
+func writeCgroupEvents(root, cgroup, contents string) {
+	GinkgoHelper()
+	dir := filepath.Join(root, cgroup)
+	Expect(os.MkdirAll(dir, 0755)).To(Succeed())
+	Expect(os.WriteFile(filepath.Join(dir, "cgroup.events"), []byte(contents), 0644)).To(Succeed())
+}
+
+var _ = Describe("cgroup events", func() {
+
+	const cgroup = "/machine.slice/libpod-1234.scope"
+
+	var root string
+	var mu sync.Mutex
+	var diags []CgroupDiagnostic
+
+	diagnosed := func() []CgroupDiagnostic {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]CgroupDiagnostic(nil), diags...)
+	}
+
+	BeforeEach(func() {
+		root = GinkgoT().TempDir()
+		diags = nil
+	})
+
+	newMonitor := func() *cgroupMonitor {
+		m := newCgroupMonitor(root, func(diag CgroupDiagnostic) {
+			mu.Lock()
+			defer mu.Unlock()
+			diags = append(diags, diag)
+		})
+		DeferCleanup(m.close)
+		return m
+	}
+
+	It("defaults to the unified hierarchy mount point", func() {
+		pw := &PodmanWatcher{podman: context.Background()}
+		WithCgroupEvents("", nil)(pw)
+		Expect(pw.cgroups.root).To(Equal(DefaultCgroupRoot))
+		Expect(pw.cgroupEvents()).To(BeFalse())
+		Expect(pw.cgroupLifecycleEvents()).To(BeNil())
+		Expect(pw.confirmCgroupEvent("1234", "died")).To(BeFalse())
+	})
+
+	It("reads cgroup.events", func() {
+		writeCgroupEvents(root, cgroup, "populated 1\nfrozen 1\n")
+		populated, frozen := Successful2R(readCgroupEvents(filepath.Join(root, cgroup, "cgroup.events")))
+		Expect(populated).To(BeTrue())
+		Expect(frozen).To(BeTrue())
+		_, _, err := readCgroupEvents(filepath.Join(root, "nada"))
+		Expect(err).To(HaveOccurred())
+		writeCgroupEvents(root, cgroup, "frozen 1\n")
+		_, _, err = readCgroupEvents(filepath.Join(root, cgroup, "cgroup.events"))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("detects pause, unpause, and exit early", func() {
+		writeCgroupEvents(root, cgroup, "populated 1\nfrozen 0\n")
+		m := newMonitor()
+		m.watch("1234", cgroup)
+		Expect(m.cntrs).To(HaveKey("1234"))
+
+		writeCgroupEvents(root, cgroup, "populated 1\nfrozen 1\n")
+		Eventually(m.events).Should(Receive(Equal(engineclient.ContainerEvent{
+			Type: engineclient.ContainerPaused, ID: "1234"})))
+		Expect(m.confirm("1234", "pause")).To(BeTrue())
+
+		writeCgroupEvents(root, cgroup, "populated 1\nfrozen 0\n")
+		Eventually(m.events).Should(Receive(Equal(engineclient.ContainerEvent{
+			Type: engineclient.ContainerUnpaused, ID: "1234"})))
+		Expect(m.confirm("1234", "unpause")).To(BeTrue())
+
+		writeCgroupEvents(root, cgroup, "populated 0\nfrozen 0\n")
+		Eventually(m.events).Should(Receive(Equal(engineclient.ContainerEvent{
+			Type: engineclient.ContainerExited, ID: "1234"})))
+		Expect(m.expects("1234", "died")).To(BeTrue())
+		Expect(m.expects("1234", "pause")).To(BeFalse())
+		Expect(m.confirm("1234", "died")).To(BeTrue())
+		Expect(m.expects("1234", "died")).To(BeFalse())
+		Expect(m.cntrs).To(BeEmpty())
+		Expect(m.files).To(BeEmpty())
+		Expect(diagnosed()).To(BeEmpty())
+	})
+
+	It("detects removed cgroups as exits", func() {
+		writeCgroupEvents(root, cgroup, "populated 1\nfrozen 0\n")
+		m := newMonitor()
+		m.watch("1234", cgroup)
+		Expect(os.RemoveAll(filepath.Join(root, cgroup))).To(Succeed())
+		Eventually(m.events).Should(Receive(Equal(engineclient.ContainerEvent{
+			Type: engineclient.ContainerExited, ID: "1234"})))
+	})
+
+	It("doesn't watch unpopulated cgroups", func() {
+		writeCgroupEvents(root, cgroup, "populated 0\nfrozen 0\n")
+		m := newMonitor()
+		m.watch("1234", cgroup)
+		m.watch("5678", "")
+		Expect(m.cntrs).To(BeEmpty())
+	})
+
+	It("reports discrepancies", func() {
+		writeCgroupEvents(root, cgroup, "populated 1\nfrozen 0\n")
+		m := newMonitor()
+		m.watch("1234", cgroup)
+		Expect(m.confirm("1234", "pause")).To(BeFalse())
+		Expect(m.confirm("1234", "died")).To(BeFalse())
+		Expect(m.confirm("5678", "died")).To(BeFalse())
+		Expect(diagnosed()).To(HaveExactElements(
+			CgroupDiagnostic{ContainerID: "1234", Event: "pause", Message: "container cgroup not frozen"},
+			CgroupDiagnostic{ContainerID: "1234", Event: "died", Message: "container cgroup still populated"},
+		))
+		Expect(m.cntrs).To(BeEmpty())
+	})
+
+	It("keeps expecting the died event of a restarted container", func() {
+		writeCgroupEvents(root, cgroup, "populated 1\nfrozen 0\n")
+		m := newMonitor()
+		m.watch("1234", cgroup)
+		writeCgroupEvents(root, cgroup, "populated 0\nfrozen 0\n")
+		Eventually(m.events).Should(Receive(Equal(engineclient.ContainerEvent{
+			Type: engineclient.ContainerExited, ID: "1234"})))
+
+		// the container gets restarted before the "died" event of its
+		// previous run arrives.
+		writeCgroupEvents(root, cgroup, "populated 1\nfrozen 0\n")
+		m.watch("1234", cgroup)
+		Expect(m.cntrs).To(HaveKey("1234"))
+		Expect(m.confirm("1234", "died")).To(BeTrue())
+		Expect(m.cntrs).To(HaveKey("1234"))
+		Expect(m.pending).To(BeEmpty())
+		Expect(diagnosed()).To(BeEmpty())
+	})
+
+})
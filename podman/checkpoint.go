@@ -0,0 +1,64 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"time"
+
+	"github.com/containers/podman/v4/libpod/define"
+	"github.com/containers/podman/v4/pkg/domain/entities"
+	"github.com/jellydator/ttlcache/v3"
+	"github.com/thediveo/whalewatcher"
+)
+
+// checkpointTTL is how long checkpointed containers are remembered in order to
+// correlate them with their restored incarnations.
+const checkpointTTL = 24 * time.Hour
+
+// recordCheckpoint remembers the container referenced by the specified
+// “checkpoint” event, so that a later restore can be correlated with the
+// checkpointed container, even if the restored container has a new ID, such as
+// when restoring from an exported checkpoint using "--import".
+func (pw *PodmanWatcher) recordCheckpoint(ev *entities.Event) {
+	pw.checkpoints.Set(ev.Actor.ID, ev.Actor.ID, ttlcache.DefaultTTL)
+	if name := ev.Actor.Attributes["name"]; name != "" {
+		pw.checkpoints.Set(name, ev.Actor.ID, ttlcache.DefaultTTL)
+	}
+}
+
+// checkpointOrigin returns the ID of the checkpointed container the container
+// with the specified ID and name has been restored from, or "" if unknown.
+func (pw *PodmanWatcher) checkpointOrigin(id, name string) string {
+	if origin := pw.checkpoints.Get(id); origin != nil {
+		return origin.Value()
+	}
+	if origin := pw.checkpoints.Get(name); origin != nil {
+		return origin.Value()
+	}
+	return ""
+}
+
+// annotateRestore annotates a container that has been restored from a
+// checkpoint with the time of its restoration and, if known, its checkpoint
+// origin.
+func (pw *PodmanWatcher) annotateRestore(cntr *whalewatcher.Container, details *define.InspectContainerData) {
+	if details.State == nil || !details.State.Restored {
+		return
+	}
+	cntr.Labels[RestoredLabelName] = details.State.RestoredAt.UTC().Format(time.RFC3339Nano)
+	if origin := pw.checkpointOrigin(details.ID, details.Name); origin != "" {
+		cntr.Labels[CheckpointOriginLabelName] = origin
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"context"
+	"time"
+
+	"github.com/containers/podman/v4/libpod/define"
+	"github.com/thediveo/whalewatcher"
+	"github.com/thediveo/whalewatcher/engineclient"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("checkpoint and restore", func() {
+
+	var pw *PodmanWatcher
+
+	BeforeEach(func() {
+		pw = NewPodmanWatcher(context.Background())
+		DeferCleanup(func() { pw.Close() })
+	})
+
+	It("records checkpoints", func() {
+		ev := newEvent("checkpoint", "1234")
+		ev.Actor.Attributes["name"] = "furious_furuncle"
+		_, ok := pw.translateEvent(context.Background(), ev)
+		Expect(ok).To(BeFalse())
+
+		Expect(pw.checkpointOrigin("1234", "")).To(Equal("1234"))
+		Expect(pw.checkpointOrigin("5678", "furious_furuncle")).To(Equal("1234"))
+		Expect(pw.checkpointOrigin("5678", "mad_mary")).To(BeEmpty())
+	})
+
+	It("refreshes restored containers", func() {
+		ev, ok := pw.translateEvent(context.Background(), newEvent("restore", "5678"))
+		Expect(ok).To(BeTrue())
+		Expect(ev.Type).To(Equal(engineclient.ContainerStarted))
+		Expect(ev.ID).To(Equal("5678"))
+	})
+
+	It("annotates restored containers", func() {
+		restoredAt := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+		pw.checkpoints.Set("furious_furuncle", "1234", 0)
+
+		cntr := &whalewatcher.Container{Labels: map[string]string{}}
+		pw.annotateRestore(cntr, &define.InspectContainerData{
+			State: &define.InspectContainerState{},
+		})
+		Expect(cntr.Labels).To(BeEmpty())
+
+		pw.annotateRestore(cntr, &define.InspectContainerData{
+			ID:   "5678",
+			Name: "furious_furuncle",
+			State: &define.InspectContainerState{
+				Restored:   true,
+				RestoredAt: restoredAt,
+			},
+		})
+		Expect(cntr.Labels).To(And(
+			HaveKeyWithValue(RestoredLabelName, "2023-04-01T12:00:00Z"),
+			HaveKeyWithValue(CheckpointOriginLabelName, "1234"),
+		))
+
+		cntr = &whalewatcher.Container{Labels: map[string]string{}}
+		pw.annotateRestore(cntr, &define.InspectContainerData{
+			ID:   "abcd",
+			Name: "mad_mary",
+			State: &define.InspectContainerState{
+				Restored:   true,
+				RestoredAt: restoredAt,
+			},
+		})
+		Expect(cntr.Labels).To(HaveKey(RestoredLabelName))
+		Expect(cntr.Labels).NotTo(HaveKey(CheckpointOriginLabelName))
+	})
+
+})
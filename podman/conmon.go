@@ -0,0 +1,274 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thediveo/sealwatcher/v2/util"
+	"github.com/thediveo/whalewatcher"
+	"github.com/thediveo/whalewatcher/engineclient"
+)
+
+// ConmonWatcher is an engine-independent [engineclient.EngineClient] that
+// discovers Podman containers by scanning a procfs for the conmon processes
+// monitoring containers. It serves as a fallback when the Podman API is
+// unreachable, such as when the Podman service has crashed or the API socket
+// isn't accessible.
+//
+// As the conmon command lines reveal only the container IDs, names, and OCI
+// bundle paths, the discovered containers lack any labels other than
+// [BundleLabelName]. Lifecycle events are derived from polling.
+type ConmonWatcher struct {
+	procroot string
+	poll     *tracker
+}
+
+// Make sure that the EngineClient interface is fully implemented.
+var _ (engineclient.EngineClient) = (*ConmonWatcher)(nil)
+
+// ConmonOption represents options to [NewConmonWatcher].
+type ConmonOption func(*ConmonWatcher)
+
+// NewConmonWatcher returns a new ConmonWatcher scanning the procfs mounted at
+// procroot for conmon processes. If procroot is empty, "/proc" is used.
+func NewConmonWatcher(procroot string, opts ...ConmonOption) *ConmonWatcher {
+	if procroot == "" {
+		procroot = "/proc"
+	}
+	cw := &ConmonWatcher{
+		procroot: procroot,
+		poll:     newTracker(DefaultPollInterval),
+	}
+	for _, opt := range opts {
+		opt(cw)
+	}
+	return cw
+}
+
+// WithConmonPollInterval sets the interval for rescanning the procfs for
+// conmon processes in order to derive lifecycle events. Zero or negative
+// intervals are ignored and the [DefaultPollInterval] is used instead.
+func WithConmonPollInterval(interval time.Duration) ConmonOption {
+	return func(cw *ConmonWatcher) {
+		if interval <= 0 {
+			return
+		}
+		cw.poll.interval = interval
+	}
+}
+
+// ID returns the procfs-based identifier of this watcher.
+func (cw *ConmonWatcher) ID(ctx context.Context) string { return cw.API() }
+
+// Type returns the type identifier for this container engine.
+func (cw *ConmonWatcher) Type() string { return Type }
+
+// Version information about this watcher; as there is no engine to ask, the
+// version is always "unknown".
+func (cw *ConmonWatcher) Version(ctx context.Context) string { return "unknown" }
+
+// API returns the procfs path this watcher scans, in form of a “file” URL.
+func (cw *ConmonWatcher) API() string { return "file://" + cw.procroot }
+
+// PID returns zero, as there is no engine process.
+func (cw *ConmonWatcher) PID() int { return 0 }
+
+// Client returns nil, as there is no engine client.
+func (cw *ConmonWatcher) Client() interface{} { return nil }
+
+// Close is a no-op, as there are no resources to release.
+func (cw *ConmonWatcher) Close() {}
+
+// List all containers with processes that are monitored by conmon processes.
+func (cw *ConmonWatcher) List(ctx context.Context) ([]*whalewatcher.Container, error) {
+	cntrs, err := cw.list(ctx)
+	if err != nil {
+		return nil, classify(ctx, err)
+	}
+	cw.poll.reset(cntrs)
+	return cntrs, nil
+}
+
+// list all containers monitored by conmon processes, without updating the
+// tracked containers.
+func (cw *ConmonWatcher) list(ctx context.Context) ([]*whalewatcher.Container, error) {
+	procs, err := scanProcs(cw.procroot)
+	if err != nil {
+		return nil, err
+	}
+	children := map[int][]int{}
+	for _, proc := range procs {
+		children[proc.ppid] = append(children[proc.ppid], proc.pid)
+	}
+	cntrs := []*whalewatcher.Container{}
+	for _, proc := range procs {
+		if proc.comm != "conmon" {
+			continue
+		}
+		cmdline, err := os.ReadFile(filepath.Join(cw.procroot, strconv.Itoa(proc.pid), "cmdline"))
+		if err != nil {
+			continue // conmon process has gone in the meantime.
+		}
+		cntr, ok := parseConmonCmdline(cmdline)
+		if !ok {
+			continue
+		}
+		// The container's initial process is the conmon's child, as conmon
+		// is a subreaper and the OCI runtime process has already exited.
+		// Without a child, the container has no processes (anymore).
+		for _, pid := range children[proc.pid] {
+			if cntr.PID == 0 || pid < cntr.PID {
+				cntr.PID = pid
+			}
+		}
+		if cntr.PID == 0 {
+			continue
+		}
+		cntrs = append(cntrs, cntr)
+	}
+	sortContainers(cntrs)
+	return cntrs, nil
+}
+
+// Inspect returns the container with the specified name or ID, where the ID
+// might be abbreviated.
+func (cw *ConmonWatcher) Inspect(ctx context.Context, nameorid string) (*whalewatcher.Container, error) {
+	cntrs, err := cw.list(ctx)
+	if err != nil {
+		return nil, classify(ctx, err)
+	}
+	for _, cntr := range cntrs {
+		if cntr.Name == nameorid || cntr.ID == nameorid {
+			return cntr, nil
+		}
+	}
+	for _, cntr := range cntrs {
+		if nameorid != "" && strings.HasPrefix(cntr.ID, nameorid) {
+			return cntr, nil
+		}
+	}
+	return nil, &util.Error{
+		Kind: util.ErrNoSuchContainer,
+		Err:  fmt.Errorf("no such container %q", nameorid),
+	}
+}
+
+// LifecycleEvents streams container lifecycle events derived from
+// periodically scanning for conmon processes.
+func (cw *ConmonWatcher) LifecycleEvents(ctx context.Context) (<-chan engineclient.ContainerEvent, <-chan error) {
+	return pollEvents(ctx, cw.poll, cw.list)
+}
+
+// procStat is the subset of a process' status needed for conmon discovery.
+type procStat struct {
+	pid  int
+	ppid int
+	comm string
+}
+
+// scanProcs returns the PIDs, PPIDs, and command names of all processes in the
+// procfs mounted at procroot. Processes that vanish while scanning are
+// skipped.
+func scanProcs(procroot string) ([]procStat, error) {
+	entries, err := os.ReadDir(procroot)
+	if err != nil {
+		return nil, err
+	}
+	procs := make([]procStat, 0, len(entries))
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		stat, err := os.ReadFile(filepath.Join(procroot, entry.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		proc, err := parseProcStat(stat)
+		if err != nil {
+			continue
+		}
+		proc.pid = pid
+		procs = append(procs, proc)
+	}
+	return procs, nil
+}
+
+// parseProcStat parses the command name and PPID from the contents of a
+// /proc/[PID]/stat file. As the command name might contain spaces and
+// parentheses, it spans from the first opening to the last closing
+// parenthesis.
+func parseProcStat(stat []byte) (procStat, error) {
+	lpar := bytes.IndexByte(stat, '(')
+	rpar := bytes.LastIndexByte(stat, ')')
+	if lpar < 0 || rpar < lpar {
+		return procStat{}, errors.New("malformed process stat")
+	}
+	fields := strings.Fields(string(stat[rpar+1:]))
+	if len(fields) < 2 {
+		return procStat{}, errors.New("malformed process stat")
+	}
+	ppid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return procStat{}, err
+	}
+	return procStat{ppid: ppid, comm: string(stat[lpar+1 : rpar])}, nil
+}
+
+// parseConmonCmdline returns the container information for the specified
+// conmon command line, as read from /proc/[PID]/cmdline. It returns false if
+// the command line doesn't identify a container, such as for exec sessions.
+func parseConmonCmdline(cmdline []byte) (*whalewatcher.Container, bool) {
+	args := strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00")
+	cntr := &whalewatcher.Container{Labels: map[string]string{}}
+	for idx := 1; idx < len(args); idx++ {
+		flag, value, hasvalue := strings.Cut(args[idx], "=")
+		switch flag {
+		case "-e", "--exec":
+			return nil, false
+		case "-c", "--cid", "-n", "--name", "-b", "--bundle":
+		default:
+			continue
+		}
+		if !hasvalue {
+			if idx+1 >= len(args) {
+				break
+			}
+			idx++
+			value = args[idx]
+		}
+		switch flag {
+		case "-c", "--cid":
+			cntr.ID = value
+		case "-n", "--name":
+			cntr.Name = value
+		case "-b", "--bundle":
+			cntr.Labels[BundleLabelName] = value
+		}
+	}
+	if cntr.ID == "" {
+		return nil, false
+	}
+	return cntr, true
+}
@@ -0,0 +1,167 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thediveo/sealwatcher/v2/util"
+	"github.com/thediveo/whalewatcher/engineclient"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/thediveo/success"
+)
+
+// fakeProc adds a process with the specified PID, PPID, command name and
+// command line to the fake procfs at procroot.
+func fakeProc(procroot string, pid, ppid int, comm string, args ...string) {
+	GinkgoHelper()
+	dir := filepath.Join(procroot, strconv.Itoa(pid))
+	Expect(os.MkdirAll(dir, 0755)).To(Succeed())
+	Expect(os.WriteFile(filepath.Join(dir, "stat"),
+		[]byte(fmt.Sprintf("%d (%s) S %d %d 0 0 -1 4194560\n", pid, comm, ppid, pid)), 0644)).To(Succeed())
+	Expect(os.WriteFile(filepath.Join(dir, "cmdline"),
+		[]byte(strings.Join(args, "\x00")+"\x00"), 0644)).To(Succeed())
+}
+
+// fakeConmon adds a conmon process for the specified container to the fake
+// procfs at procroot.
+func fakeConmon(procroot string, pid int, id, name string, extra ...string) {
+	GinkgoHelper()
+	args := append([]string{
+		"/usr/bin/conmon", "--api-version", "1",
+		"-c", id, "-u", id,
+		"-r", "/usr/bin/crun",
+		"-b", "/var/lib/containers/storage/overlay-containers/" + id + "/userdata",
+		"-n", name,
+		"--exit-command", "/usr/bin/podman",
+	}, extra...)
+	fakeProc(procroot, pid, 1, "conmon", args...)
+}
+
+var _ = Describe("conmon-based discovery", func() {
+
+	var procroot string
+	var ctx context.Context
+
+	BeforeEach(func() {
+		procroot = GinkgoT().TempDir()
+		ctx = context.Background()
+		fakeProc(procroot, 1, 0, "systemd", "/sbin/init")
+		Expect(os.MkdirAll(filepath.Join(procroot, "self"), 0755)).To(Succeed())
+	})
+
+	It("parses process stats", func() {
+		proc := Successful(parseProcStat([]byte("42 (foo) (bar)) S 1 42 0")))
+		Expect(proc.comm).To(Equal("foo) (bar)"))
+		Expect(proc.ppid).To(Equal(1))
+		Expect(parseProcStat([]byte("42 foo S 1"))).Error().To(HaveOccurred())
+		Expect(parseProcStat([]byte("42 (foo) S"))).Error().To(HaveOccurred())
+	})
+
+	DescribeTable("parsing conmon command lines",
+		func(cmdline string, id, name, bundle string) {
+			cntr, ok := parseConmonCmdline([]byte(cmdline))
+			if id == "" {
+				Expect(ok).To(BeFalse())
+				return
+			}
+			Expect(ok).To(BeTrue())
+			Expect(cntr.ID).To(Equal(id))
+			Expect(cntr.Name).To(Equal(name))
+			Expect(cntr.Labels).To(HaveKeyWithValue(BundleLabelName, bundle))
+		},
+		Entry(nil, "conmon\x00-c\x001234\x00-n\x00foo\x00-b\x00/bundle\x00", "1234", "foo", "/bundle"),
+		Entry(nil, "conmon\x00--cid=1234\x00--name=foo\x00--bundle=/bundle", "1234", "foo", "/bundle"),
+		Entry(nil, "conmon\x00-c\x001234\x00-e\x00-b\x00/bundle\x00", "", "", ""),
+		Entry(nil, "conmon\x00-n\x00foo\x00", "", "", ""),
+		Entry(nil, "conmon\x00-c\x00", "", "", ""),
+	)
+
+	It("lists and inspects containers", func() {
+		fakeConmon(procroot, 100, "1234567890", "foo")
+		fakeProc(procroot, 101, 100, "sleep", "/bin/sleep", "infinity")
+		fakeConmon(procroot, 200, "abcdef", "bar")
+		fakeProc(procroot, 202, 200, "nginx", "nginx")
+		fakeProc(procroot, 201, 200, "nginx", "nginx")
+		fakeConmon(procroot, 300, "dead", "dead")
+		fakeConmon(procroot, 400, "1234567890", "foo", "-e", "--exec-attach")
+		fakeProc(procroot, 401, 400, "sh", "/bin/sh")
+		fakeProc(procroot, 500, 1, "podman", "podman", "-c", "666")
+
+		cw := NewConmonWatcher(procroot)
+		defer cw.Close()
+		Expect(cw.API()).To(Equal("file://" + procroot))
+		Expect(cw.ID(ctx)).To(Equal(cw.API()))
+		Expect(cw.Type()).To(Equal(Type))
+		Expect(cw.PID()).To(BeZero())
+		Expect(cw.Client()).To(BeNil())
+
+		cntrs := Successful(cw.List(ctx))
+		Expect(cntrs).To(HaveLen(2))
+		Expect(*cntrs[0]).To(And(
+			HaveField("ID", "abcdef"),
+			HaveField("Name", "bar"),
+			HaveField("PID", 201)))
+		Expect(*cntrs[1]).To(And(
+			HaveField("ID", "1234567890"),
+			HaveField("Name", "foo"),
+			HaveField("PID", 101),
+			HaveField("Labels", HaveKeyWithValue(BundleLabelName,
+				"/var/lib/containers/storage/overlay-containers/1234567890/userdata"))))
+
+		Expect(cw.Inspect(ctx, "bar")).To(HaveField("ID", "abcdef"))
+		Expect(cw.Inspect(ctx, "1234")).To(HaveField("Name", "foo"))
+		Expect(cw.Inspect(ctx, "dead")).Error().To(MatchError(util.ErrNoSuchContainer))
+		Expect(cw.Inspect(ctx, "")).Error().To(MatchError(util.ErrNoSuchContainer))
+	})
+
+	It("polls for lifecycle events", func() {
+		fakeConmon(procroot, 100, "1234", "foo")
+		fakeProc(procroot, 101, 100, "sleep", "/bin/sleep", "infinity")
+
+		cw := NewConmonWatcher(procroot, WithConmonPollInterval(50*time.Millisecond))
+		Expect(cw.List(ctx)).To(HaveLen(1))
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		evs, errs := cw.LifecycleEvents(ctx)
+
+		fakeConmon(procroot, 200, "5678", "bar")
+		fakeProc(procroot, 201, 200, "sleep", "/bin/sleep", "infinity")
+		Eventually(evs).Should(Receive(Equal(engineclient.ContainerEvent{
+			Type: engineclient.ContainerStarted, ID: "5678"})))
+
+		Expect(os.RemoveAll(filepath.Join(procroot, "101"))).To(Succeed())
+		Eventually(evs).Should(Receive(Equal(engineclient.ContainerEvent{
+			Type: engineclient.ContainerExited, ID: "1234"})))
+
+		cancel()
+		Eventually(errs).Should(Receive(MatchError(context.Canceled)))
+	})
+
+	It("fails for a missing procfs", func() {
+		cw := NewConmonWatcher(filepath.Join(procroot, "nada"))
+		Expect(cw.List(ctx)).Error().To(HaveOccurred())
+		Expect(NewConmonWatcher("").procroot).To(Equal("/proc"))
+	})
+
+})
@@ -0,0 +1,215 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"time"
+
+	"github.com/containers/podman/v4/libpod/define"
+	"github.com/thediveo/whalewatcher"
+	"github.com/thediveo/whalewatcher/engineclient"
+)
+
+// DetailsRucksackKey is the [MultiRucksack] key for a container's
+// [PodmanDetails].
+const DetailsRucksackKey = PodmanAnnotation + "details"
+
+// PodmanDetails are selected container details, in a stable form that doesn't
+// depend on the (ever-changing) types vendored with the Podman API client.
+type PodmanDetails struct {
+	ID         string
+	Name       string
+	Created    time.Time
+	ImageID    string
+	ImageName  string
+	State      ContainerState
+	Config     ContainerConfig
+	HostConfig ContainerHostConfig
+	Pod        *PodInfo // nil if the container doesn't belong to a pod.
+}
+
+// ContainerState describes the state of a container at the time it was
+// inspected.
+type ContainerState struct {
+	Status     string // such as "running", "paused", "exited", ...
+	Running    bool
+	Paused     bool
+	Restarting bool
+	OOMKilled  bool
+	Dead       bool
+	PID        int // PID of the container's initial process, if any.
+	ConmonPID  int // PID of the container's conmon monitor process, if any.
+	ExitCode   int32
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Health     string // health check status, if any.
+	CgroupPath string
+}
+
+// ContainerConfig describes the (initial) configuration of a container.
+type ContainerConfig struct {
+	Hostname    string
+	User        string
+	Env         []string
+	Cmd         []string
+	Entrypoint  string
+	WorkingDir  string
+	Labels      map[string]string
+	Annotations map[string]string
+	Tty         bool
+	StopSignal  uint
+}
+
+// ContainerHostConfig describes the host-related configuration of a container,
+// such as its namespaces and privileges.
+type ContainerHostConfig struct {
+	Privileged     bool
+	ReadonlyRootfs bool
+	CapAdd         []string
+	CapDrop        []string
+	SecurityOpt    []string
+	NetworkMode    string
+	PidMode        string
+	IpcMode        string
+	UTSMode        string
+	UsernsMode     string
+	CgroupMode     string
+	CgroupParent   string
+	RestartPolicy  string
+	AutoRemove     bool
+}
+
+// PodInfo describes the pod a container belongs to.
+type PodInfo struct {
+	ID    string
+	Name  string // empty if the pod name couldn't be determined.
+	Infra bool   // true if the container is the pod's infra container.
+}
+
+// DetailsPacker is a [engineclient.RucksackPacker] that packs the
+// [PodmanDetails] of a container into its Rucksack, using [PackDetails]. Use
+// [ContainerDetails] to retrieve them later.
+type DetailsPacker struct{}
+
+var _ (engineclient.RucksackPacker) = (*DetailsPacker)(nil)
+
+// Pack the Podman details of the specified container into its Rucksack, given
+// the container's inspection data in form of a [define.InspectContainerData].
+func (p DetailsPacker) Pack(container *whalewatcher.Container, inspection interface{}) {
+	details, ok := inspection.(*define.InspectContainerData)
+	if !ok || details == nil {
+		return
+	}
+	PackDetails(container, NewPodmanDetails(details, container.Labels[PodLabelName]))
+}
+
+// PackDetails packs the specified Podman details into the container's
+// [MultiRucksack].
+func PackDetails(cntr *whalewatcher.Container, details *PodmanDetails) {
+	PackRucksack(cntr, DetailsRucksackKey, details)
+}
+
+// ContainerDetails returns the Podman details of the specified container, or
+// nil if there are none, such as when no [DetailsPacker] was used.
+func ContainerDetails(cntr *whalewatcher.Container) *PodmanDetails {
+	details, _ := UnpackRucksack[*PodmanDetails](cntr, DetailsRucksackKey)
+	return details
+}
+
+// NewPodmanDetails returns the Podman details for the specified container
+// inspection data. As the inspection data references pods only by ID, the pod
+// name needs to be supplied separately, if known.
+func NewPodmanDetails(details *define.InspectContainerData, podname string) *PodmanDetails {
+	d := &PodmanDetails{
+		ID:        details.ID,
+		Name:      details.Name,
+		Created:   details.Created,
+		ImageID:   details.Image,
+		ImageName: details.ImageName,
+	}
+	if state := details.State; state != nil {
+		d.State = ContainerState{
+			Status:     state.Status,
+			Running:    state.Running,
+			Paused:     state.Paused,
+			Restarting: state.Restarting,
+			OOMKilled:  state.OOMKilled,
+			Dead:       state.Dead,
+			PID:        state.Pid,
+			ConmonPID:  state.ConmonPid,
+			ExitCode:   state.ExitCode,
+			StartedAt:  state.StartedAt,
+			FinishedAt: state.FinishedAt,
+			Health:     state.Health.Status,
+			CgroupPath: state.CgroupPath,
+		}
+	}
+	if config := details.Config; config != nil {
+		d.Config = ContainerConfig{
+			Hostname:    config.Hostname,
+			User:        config.User,
+			Env:         config.Env,
+			Cmd:         config.Cmd,
+			Entrypoint:  config.Entrypoint,
+			WorkingDir:  config.WorkingDir,
+			Labels:      copyLabels(config.Labels),
+			Annotations: copyLabels(config.Annotations),
+			Tty:         config.Tty,
+			StopSignal:  config.StopSignal,
+		}
+	}
+	if hostconfig := details.HostConfig; hostconfig != nil {
+		d.HostConfig = ContainerHostConfig{
+			Privileged:     hostconfig.Privileged,
+			ReadonlyRootfs: hostconfig.ReadonlyRootfs,
+			CapAdd:         hostconfig.CapAdd,
+			CapDrop:        hostconfig.CapDrop,
+			SecurityOpt:    hostconfig.SecurityOpt,
+			NetworkMode:    hostconfig.NetworkMode,
+			PidMode:        hostconfig.PidMode,
+			IpcMode:        hostconfig.IpcMode,
+			UTSMode:        hostconfig.UTSMode,
+			UsernsMode:     hostconfig.UsernsMode,
+			CgroupMode:     hostconfig.CgroupMode,
+			CgroupParent:   hostconfig.CgroupParent,
+			AutoRemove:     hostconfig.AutoRemove,
+		}
+		if hostconfig.RestartPolicy != nil {
+			d.HostConfig.RestartPolicy = hostconfig.RestartPolicy.Name
+		}
+	}
+	if details.Pod != "" {
+		d.Pod = &PodInfo{
+			ID:    details.Pod,
+			Name:  podname,
+			Infra: details.IsInfra,
+		}
+	}
+	return d
+}
+
+// copyLabels returns a copy of the specified labels, or nil if there are no
+// labels, so that the copy can be modified without affecting the original
+// labels, and vice versa.
+func copyLabels(labels map[string]string) map[string]string {
+	if labels == nil {
+		return nil
+	}
+	c := make(map[string]string, len(labels))
+	for k, v := range labels {
+		c[k] = v
+	}
+	return c
+}
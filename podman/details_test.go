@@ -0,0 +1,127 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"time"
+
+	"github.com/containers/podman/v4/libpod/define"
+	"github.com/thediveo/whalewatcher"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Podman details", func() {
+
+	It("ignores unexpected inspection data", func() {
+		cntr := &whalewatcher.Container{}
+		DetailsPacker{}.Pack(cntr, 42)
+		Expect(cntr.Rucksack).To(BeNil())
+		Expect(ContainerDetails(cntr)).To(BeNil())
+	})
+
+	It("packs minimal details", func() {
+		cntr := &whalewatcher.Container{}
+		DetailsPacker{}.Pack(cntr, &define.InspectContainerData{
+			ID:   "1234",
+			Name: "furious_furuncle",
+		})
+		Expect(ContainerDetails(cntr)).To(Equal(&PodmanDetails{
+			ID:   "1234",
+			Name: "furious_furuncle",
+		}))
+	})
+
+	It("packs details", func() {
+		now := time.Now()
+		cntr := &whalewatcher.Container{
+			Labels: map[string]string{PodLabelName: "dizzy_lizzy"},
+		}
+		DetailsPacker{}.Pack(cntr, &define.InspectContainerData{
+			ID:        "1234",
+			Name:      "furious_furuncle",
+			Created:   now,
+			Image:     "5678",
+			ImageName: "docker.io/library/busybox:latest",
+			Pod:       "abcd",
+			State: &define.InspectContainerState{
+				Status:    "running",
+				Running:   true,
+				Pid:       42,
+				ConmonPid: 41,
+				StartedAt: now,
+				Health:    define.HealthCheckResults{Status: "healthy"},
+			},
+			Config: &define.InspectContainerConfig{
+				Hostname: "furuncle",
+				Cmd:      []string{"sleep", "60"},
+				Labels:   map[string]string{"foo": "bar"},
+			},
+			HostConfig: &define.InspectContainerHostConfig{
+				Privileged:    true,
+				NetworkMode:   "container:efgh",
+				RestartPolicy: &define.InspectRestartPolicy{Name: "always"},
+			},
+		})
+		details := ContainerDetails(cntr)
+		Expect(details).NotTo(BeNil())
+		Expect(details.ImageID).To(Equal("5678"))
+		Expect(details.ImageName).To(Equal("docker.io/library/busybox:latest"))
+		Expect(details.State).To(Equal(ContainerState{
+			Status:    "running",
+			Running:   true,
+			PID:       42,
+			ConmonPID: 41,
+			StartedAt: now,
+			Health:    "healthy",
+		}))
+		Expect(details.Config.Hostname).To(Equal("furuncle"))
+		Expect(details.Config.Cmd).To(ConsistOf("sleep", "60"))
+		Expect(details.Config.Labels).To(HaveKeyWithValue("foo", "bar"))
+		Expect(details.HostConfig.Privileged).To(BeTrue())
+		Expect(details.HostConfig.NetworkMode).To(Equal("container:efgh"))
+		Expect(details.HostConfig.RestartPolicy).To(Equal("always"))
+		Expect(details.Pod).To(Equal(&PodInfo{ID: "abcd", Name: "dizzy_lizzy"}))
+	})
+
+	It("doesn't alias the inspected labels and annotations", func() {
+		labels := map[string]string{"foo": "bar"}
+		annotations := map[string]string{"baz": "qux"}
+		cntr := &whalewatcher.Container{Labels: labels}
+		DetailsPacker{}.Pack(cntr, &define.InspectContainerData{
+			ID: "1234",
+			Config: &define.InspectContainerConfig{
+				Labels:      labels,
+				Annotations: annotations,
+			},
+		})
+		labels[PodmanAnnotation+"foo"] = "bar"
+		annotations[PodmanAnnotation+"baz"] = "qux"
+		details := ContainerDetails(cntr)
+		Expect(details).NotTo(BeNil())
+		Expect(details.Config.Labels).To(Equal(map[string]string{"foo": "bar"}))
+		Expect(details.Config.Annotations).To(Equal(map[string]string{"baz": "qux"}))
+	})
+
+	It("coexists with other rucksack items", func() {
+		cntr := &whalewatcher.Container{}
+		PackRucksack(cntr, NetworksRucksackKey, &NetworkInfo{})
+		PackDetails(cntr, &PodmanDetails{ID: "1234"})
+		Expect(ContainerNetworks(cntr)).NotTo(BeNil())
+		Expect(ContainerDetails(cntr)).To(HaveField("ID", "1234"))
+	})
+
+})
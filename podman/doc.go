@@ -33,6 +33,22 @@ they trip up the [Gomega go routine leak detector]. This engine client
 implementation works around this issue by forcing the HTTP client used by a
 Podman connection to close all idle connections.
 
+# Rucksacks
+
+Besides the annotation labels, this engine client optionally attaches typed
+container information to the Rucksack of a [whalewatcher.Container]: in this
+case, the Rucksack becomes a [MultiRucksack] carrying multiple items, each one
+under its own key. [UnpackRucksack] then returns a particular item with its
+proper type. For convenience, [ContainerNetworks] and [ContainerDetails]
+return the network information and the stable Podman container details
+respectively.
+
+Please note that enabling network information never changes the type of a
+plain Rucksack packed by an application's own Rucksack packer: such
+containers then lack network information. Applications wanting both need to
+set their packer using [WithKeyedRucksackPacker] instead, so that the
+Rucksack becomes a MultiRucksack.
+
 # Podman Incompatibility
 
 Podman v3 and v4 aren't correctly implementing the container “died” event as
@@ -0,0 +1,261 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containers/podman/v4/libpod/events"
+	"github.com/containers/podman/v4/pkg/domain/entities"
+	"github.com/fsnotify/fsnotify"
+	"github.com/thediveo/whalewatcher/engineclient"
+)
+
+// DefaultEventsLogPath is the default path of the events log file of a
+// rootful Podman using the “file” events backend. Rootless Podman instead
+// defaults to $XDG_RUNTIME_DIR/libpod/tmp/events/events.log.
+const DefaultEventsLogPath = "/run/libpod/events/events.log"
+
+// rotateEventAttribute is the attribute of Podman's “log-rotation” events
+// marking the beginning and end of the events carried over from the events
+// log file before its rotation.
+const (
+	rotateEventAttribute = "io.podman.event.rotate"
+	rotateEventBegin     = "begin"
+	rotateEventEnd       = "end"
+)
+
+// WithEventsLog tails Podman's events log file at the specified path as the
+// source of lifecycle events, instead of the engine API's event stream. This
+// requires Podman to use the “file” events backend. As the events log file is
+// written directly by Podman, events arrive with lower latency. Rotations of
+// the events log file are handled transparently. If path is empty,
+// [DefaultEventsLogPath] is used.
+//
+// Please note that Podman's API service is still required: watching only
+// starts after connecting to the API service, and the watcher lists and
+// inspects containers using the API service.
+func WithEventsLog(path string) NewOption {
+	return func(pw *PodmanWatcher) {
+		if path == "" {
+			path = DefaultEventsLogPath
+		}
+		pw.eventslog = path
+	}
+}
+
+// tailLifecycleEvents streams lifecycle events derived from the events
+// appended to Podman's events log file.
+func (pw *PodmanWatcher) tailLifecycleEvents(svcctx context.Context) (<-chan engineclient.ContainerEvent, <-chan error) {
+	cntreventstream := make(chan engineclient.ContainerEvent)
+	cntrerrstream := make(chan error, 1)
+
+	go func() {
+		defer close(cntrerrstream)
+		if err := pw.tailEventsLog(svcctx, cntreventstream); err != nil {
+			cntrerrstream <- classify(svcctx, err)
+		}
+	}()
+
+	return cntreventstream, cntrerrstream
+}
+
+// tailEventsLog watches the events log file, sending lifecycle events down the
+// specified event stream, until the context gets cancelled or an error occurs.
+// Only events appended after tailing started are taken into account.
+func (pw *PodmanWatcher) tailEventsLog(ctx context.Context, cntreventstream chan<- engineclient.ContainerEvent) error {
+	// As Podman rotates its events log file by renaming a new file over the
+	// existing one, we need to watch the directory the log file is in.
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	if err := watcher.Add(filepath.Dir(pw.eventslog)); err != nil {
+		return err
+	}
+	log := newEventsLog(pw.eventslog)
+	defer log.close()
+	if err := log.open(false); err != nil {
+		return err
+	}
+
+	resyncctx, cancelresync := context.WithCancel(ctx)
+	defer cancelresync()
+	resyncs := pw.resyncListings(resyncctx)
+
+	statuses := map[string]struct{}{}
+	for _, status := range pw.eventStatuses() {
+		statuses[status] = struct{}{}
+	}
+	forward := func(ev *events.Event) {
+		if ev.Type != events.Container {
+			return
+		}
+		if _, ok := statuses[ev.Status.String()]; !ok {
+			return
+		}
+		if pw.selector != nil && len(pw.selector.Names) > 0 &&
+			!contains(pw.selector.Names, ev.Name) {
+			return
+		}
+		pw.forwardEvent(ctx, entities.ConvertToEntitiesEvent(*ev), cntreventstream)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-watcher.Errors:
+			return err
+		case fsev := <-watcher.Events:
+			if filepath.Clean(fsev.Name) != filepath.Clean(pw.eventslog) {
+				continue
+			}
+			switch {
+			case fsev.Has(fsnotify.Create):
+				// The events log file has been (re)created or rotated, so
+				// read what's left in the old file and then switch over to
+				// the new file, skipping the events carried over.
+				if err := log.read(forward); err != nil {
+					return err
+				}
+				log.close()
+				if err := log.open(true); err != nil {
+					return err
+				}
+				if err := log.read(forward); err != nil {
+					return err
+				}
+			case fsev.Has(fsnotify.Write):
+				if err := log.read(forward); err != nil {
+					return err
+				}
+			}
+		case id := <-pw.earlyExitEvents():
+			pw.forwardPidfdExit(id, cntreventstream)
+		case cntrev := <-pw.cgroupLifecycleEvents():
+			pw.forwardCgroupEvent(cntrev, cntreventstream)
+		case listing := <-resyncs:
+			pw.forwardResync(listing, cntreventstream)
+		}
+	}
+}
+
+// eventsLog reads Podman events from an events log file.
+type eventsLog struct {
+	path    string
+	f       *os.File
+	r       *bufio.Reader
+	partial string // partial last line read so far.
+	first   bool   // next event is the first one in a reopened log file.
+	skip    bool   // skip events carried over from before a log rotation.
+}
+
+// newEventsLog returns a new events log reader for the specified path.
+func newEventsLog(path string) *eventsLog {
+	return &eventsLog{path: path}
+}
+
+// open the events log file. If the file doesn't exist (yet), opening succeeds
+// nevertheless and reading starts only after the file has been created. When
+// reopening after a log rotation, events carried over from the previous log
+// file get skipped; otherwise, reading starts at the end of the log file.
+func (l *eventsLog) open(reopen bool) error {
+	f, err := os.Open(l.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if !reopen {
+		if _, err := f.Seek(0, io.SeekEnd); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	l.f = f
+	l.r = bufio.NewReader(f)
+	l.partial = ""
+	l.first = reopen
+	l.skip = false
+	return nil
+}
+
+// close the events log file, if open.
+func (l *eventsLog) close() {
+	if l.f == nil {
+		return
+	}
+	l.f.Close()
+	l.f = nil
+	l.r = nil
+}
+
+// read all complete events available so far, calling fn for each event. Lines
+// that cannot be parsed are skipped. If the log file isn't open, read does
+// nothing.
+func (l *eventsLog) read(fn func(*events.Event)) error {
+	if l.f == nil {
+		return nil // the log file didn't exist (yet) when trying to open it.
+	}
+	for {
+		line, err := l.r.ReadString('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				l.partial += line
+				return nil
+			}
+			return err
+		}
+		line = l.partial + line
+		l.partial = ""
+		ev := &events.Event{}
+		if json.Unmarshal([]byte(strings.TrimSpace(line)), ev) != nil {
+			continue
+		}
+		if l.skipEvent(ev) {
+			continue
+		}
+		fn(ev)
+	}
+}
+
+// skipEvent returns true if the specified event needs to be skipped, because
+// it either is a rotation event or has been carried over from before a log
+// rotation. A rotated log file starts with a “begin” rotation event, followed
+// by the carried over events, and then an “end” rotation event.
+func (l *eventsLog) skipEvent(ev *events.Event) bool {
+	first := l.first
+	l.first = false
+	if ev.Status == events.Rotate {
+		switch ev.Attributes[rotateEventAttribute] {
+		case rotateEventBegin:
+			l.skip = first
+		case rotateEventEnd:
+			l.skip = false
+		}
+		return true
+	}
+	return l.skip
+}
@@ -0,0 +1,155 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/containers/podman/v4/libpod/events"
+	"github.com/thediveo/whalewatcher/engineclient"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/thediveo/success"
+)
+
+// logEvent returns the events log line for the specified event.
+func logEvent(typ events.Type, status events.Status, id string, attrs map[string]string) string {
+	ev := events.Event{
+		ID:     id,
+		Name:   "name-" + id,
+		Status: status,
+		Time:   time.Now(),
+		Type:   typ,
+	}
+	ev.Attributes = attrs
+	return string(Successful(json.Marshal(ev))) + "\n"
+}
+
+// rotateEvent returns the events log line for a rotation event.
+func rotateEvent(marker string) string {
+	return logEvent(events.System, events.Rotate, "", map[string]string{rotateEventAttribute: marker})
+}
+
+// appendLog appends the specified lines to the events log file at path.
+func appendLog(path string, lines ...string) {
+	GinkgoHelper()
+	f := Successful(os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644))
+	defer f.Close()
+	for _, line := range lines {
+		Expect(f.WriteString(line)).Error().NotTo(HaveOccurred())
+	}
+}
+
+var _ = Describe("events log", func() {
+
+	var path string
+
+	BeforeEach(func() {
+		path = filepath.Join(GinkgoT().TempDir(), "events.log")
+	})
+
+	It("defaults to the rootful events log path", func() {
+		pw := &PodmanWatcher{}
+		WithEventsLog("")(pw)
+		Expect(pw.eventslog).To(Equal(DefaultEventsLogPath))
+		WithEventsLog(path)(pw)
+		Expect(pw.eventslog).To(Equal(path))
+	})
+
+	It("reads only new events, including partially written ones", func() {
+		appendLog(path, logEvent(events.Container, events.Start, "old", nil))
+		log := newEventsLog(path)
+		defer log.close()
+		Expect(log.open(false)).To(Succeed())
+
+		var ids []string
+		collect := func(ev *events.Event) { ids = append(ids, ev.ID) }
+		line := logEvent(events.Container, events.Start, "1234", nil)
+		appendLog(path, "garbage\n", line[:10])
+		Expect(log.read(collect)).To(Succeed())
+		Expect(ids).To(BeEmpty())
+		appendLog(path, line[10:])
+		Expect(log.read(collect)).To(Succeed())
+		Expect(ids).To(ConsistOf("1234"))
+	})
+
+	It("skips events carried over from a rotation", func() {
+		appendLog(path,
+			rotateEvent(rotateEventBegin),
+			logEvent(events.Container, events.Start, "old", nil),
+			rotateEvent(rotateEventEnd),
+			logEvent(events.Container, events.Exited, "1234", nil))
+		log := newEventsLog(path)
+		defer log.close()
+		Expect(log.open(true)).To(Succeed())
+		var ids []string
+		Expect(log.read(func(ev *events.Event) { ids = append(ids, ev.ID) })).To(Succeed())
+		Expect(ids).To(ConsistOf("1234"))
+	})
+
+	It("doesn't skip events in a newly created log", func() {
+		appendLog(path,
+			logEvent(events.Container, events.Start, "1234", nil),
+			rotateEvent(rotateEventBegin),
+			logEvent(events.Container, events.Exited, "1234", nil))
+		log := newEventsLog(path)
+		defer log.close()
+		Expect(log.open(true)).To(Succeed())
+		var ids []string
+		Expect(log.read(func(ev *events.Event) { ids = append(ids, ev.ID) })).To(Succeed())
+		Expect(ids).To(HaveExactElements("1234", "1234"))
+	})
+
+	It("tails the events log across rotations", func() {
+		appendLog(path, logEvent(events.Container, events.Start, "old", nil))
+		pw := &PodmanWatcher{eventslog: path}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		evs, errs := pw.tailLifecycleEvents(ctx)
+		// give the tailer a chance to settle at the end of the events log.
+		time.Sleep(100 * time.Millisecond)
+
+		appendLog(path,
+			logEvent(events.Image, events.Pull, "deadbeef", nil),
+			logEvent(events.Container, events.Create, "1234", nil),
+			logEvent(events.Container, events.Start, "1234", nil))
+		Eventually(evs).Should(Receive(Equal(engineclient.ContainerEvent{
+			Type: engineclient.ContainerStarted,
+			ID:   "1234",
+		})))
+
+		tmp := filepath.Join(filepath.Dir(path), "rotating")
+		appendLog(tmp,
+			rotateEvent(rotateEventBegin),
+			logEvent(events.Container, events.Start, "1234", nil),
+			rotateEvent(rotateEventEnd),
+			logEvent(events.Container, events.Exited, "1234", nil))
+		Expect(os.Rename(tmp, path)).To(Succeed())
+		Eventually(evs).Should(Receive(Equal(engineclient.ContainerEvent{
+			Type: engineclient.ContainerExited,
+			ID:   "1234",
+		})))
+		Consistently(evs).ShouldNot(Receive())
+
+		cancel()
+		Eventually(errs).Should(Receive(MatchError(context.Canceled)))
+	})
+
+})
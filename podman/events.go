@@ -0,0 +1,216 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"context"
+
+	"github.com/containers/podman/v4/pkg/bindings/containers"
+	"github.com/containers/podman/v4/pkg/domain/entities"
+	"github.com/thediveo/whalewatcher/engineclient"
+	"github.com/thediveo/whalewatcher/engineclient/moby"
+)
+
+// eventStatuses returns the statuses of the container events to subscribe to,
+// depending on the options of this watcher.
+func (pw *PodmanWatcher) eventStatuses() []string {
+	statuses := []string{
+		"start",
+		"died",
+		"pause",
+		"unpause",
+		"checkpoint",
+		"restore",
+	}
+	if pw.inventory {
+		statuses = append(statuses, "create", "init", "cleanup", "remove")
+	}
+	if pw.execs != nil {
+		statuses = append(statuses, "exec", "exec_died")
+	}
+	return statuses
+}
+
+// translateEvent translates a Podman container event into a container
+// lifecycle event, returning true if the Podman event is of interest;
+// otherwise, it returns false.
+func (pw *PodmanWatcher) translateEvent(ctx context.Context, ev *entities.Event) (engineclient.ContainerEvent, bool) {
+	// This is pretty much boilerplate, as even Podman's own events are
+	// Docker-compatible. Red Dan must still be fuming.
+	switch ev.Action {
+	case "start":
+		if pw.selector != nil && !pw.selector.matchesEvent(ctx, pw, ev.Actor.Attributes) {
+			return engineclient.ContainerEvent{}, false
+		}
+		// Podman's events don't tell us whether a container is an infra
+		// container, so we need to find out the hard way. We don't need to do
+		// this for the other events, as the watcher ignores events for
+		// containers it doesn't know of.
+		if pw.inframode != InfraShow && ev.Actor.Attributes["podId"] != "" &&
+			pw.isInfraID(ctx, ev.Actor.ID) {
+			return engineclient.ContainerEvent{}, false
+		}
+		return engineclient.ContainerEvent{
+			Type:    engineclient.ContainerStarted,
+			ID:      ev.Actor.ID,
+			Project: ev.Actor.Attributes[moby.ComposerProjectLabel],
+		}, true
+	case "died":
+		if pw.execs != nil {
+			pw.forgetExecSessions(ev.Actor.ID)
+		}
+		pw.forgetSharedNetworkInfo(ev.Actor.ID)
+		pw.forgetInfraDetails(ev.Actor.Attributes["podId"])
+		// Make sure to always let all early detectors know about the “died”
+		// event, so they can clean up.
+		early := pw.earlyExits() && pw.pidfds.died(ev.Actor.ID)
+		if pw.confirmCgroupEvent(ev.Actor.ID, "died") || early {
+			return engineclient.ContainerEvent{}, false
+		}
+		if pw.inventory {
+			return pw.refreshEvent(ctx, ev)
+		}
+		return engineclient.ContainerEvent{
+			Type: engineclient.ContainerExited,
+			ID:   ev.Actor.ID,
+			// Please note that Podmen v3 and v4 lack support for container
+			// labels in "died" events; the default watcher implementation
+			// will work around this by looking up the project label if known.
+			Project: ev.Actor.Attributes[moby.ComposerProjectLabel],
+		}, true
+	case "pause":
+		if pw.confirmCgroupEvent(ev.Actor.ID, "pause") {
+			break
+		}
+		return engineclient.ContainerEvent{
+			Type:    engineclient.ContainerPaused,
+			ID:      ev.Actor.ID,
+			Project: ev.Actor.Attributes[moby.ComposerProjectLabel],
+		}, true
+	case "unpause":
+		if pw.confirmCgroupEvent(ev.Actor.ID, "unpause") {
+			break
+		}
+		return engineclient.ContainerEvent{
+			Type:    engineclient.ContainerUnpaused,
+			ID:      ev.Actor.ID,
+			Project: ev.Actor.Attributes[moby.ComposerProjectLabel],
+		}, true
+	case "checkpoint":
+		// Checkpointing a container either leaves it running or it
+		// terminates it, with Podman then emitting a separate “died” event.
+		pw.recordCheckpoint(ev)
+	case "restore":
+		// The restored container might have a new ID, so make sure that the
+		// watcher inspects it and thus picks up its checkpoint origin.
+		return pw.refreshEvent(ctx, ev)
+	case "exec", "exec_died":
+		if pw.execs != nil {
+			pw.refreshExecSessions(ctx, ev.Actor.ID)
+		}
+	case "create", "init", "cleanup":
+		if !pw.inventory {
+			break
+		}
+		return pw.refreshEvent(ctx, ev)
+	case "remove":
+		if pw.execs != nil {
+			pw.forgetExecSessions(ev.Actor.ID)
+		}
+		if !pw.inventory {
+			break
+		}
+		return engineclient.ContainerEvent{
+			Type:    engineclient.ContainerExited,
+			ID:      ev.Actor.ID,
+			Project: ev.Actor.Attributes[moby.ComposerProjectLabel],
+		}, true
+	}
+	return engineclient.ContainerEvent{}, false
+}
+
+// forwardEvent translates the specified Podman event and, if it is of
+// interest, sends the resulting lifecycle event down the specified event
+// stream, keeping any resynchronization tracker up to date.
+func (pw *PodmanWatcher) forwardEvent(ctx context.Context, ev *entities.Event, cntreventstream chan<- engineclient.ContainerEvent) {
+	cntrev, ok := pw.translateEvent(ctx, ev)
+	if !ok {
+		return
+	}
+	if pw.resync != nil {
+		pw.resync.observe(cntrev)
+	}
+	cntreventstream <- cntrev
+}
+
+// forwardEarly sends the specified lifecycle event down the specified event
+// stream, keeping any resynchronization tracker up to date. forwardEarly is
+// used for lifecycle changes detected early, before Podman emits its
+// corresponding event.
+func (pw *PodmanWatcher) forwardEarly(cntrev engineclient.ContainerEvent, cntreventstream chan<- engineclient.ContainerEvent) {
+	if pw.resync != nil {
+		pw.resync.observe(cntrev)
+	}
+	cntreventstream <- cntrev
+}
+
+// forwardPidfdExit sends an early ContainerExited lifecycle event for the
+// container with the specified ID down the specified event stream, unless the
+// container's exit has already been detected from its cgroup.
+func (pw *PodmanWatcher) forwardPidfdExit(id string, cntreventstream chan<- engineclient.ContainerEvent) {
+	if pw.cgroupEvents() && pw.cgroups.expects(id, "died") {
+		return
+	}
+	pw.forwardEarly(engineclient.ContainerEvent{
+		Type: engineclient.ContainerExited,
+		ID:   id,
+	}, cntreventstream)
+}
+
+// forwardCgroupEvent sends the specified lifecycle event derived from cgroup
+// events down the specified event stream, unless it is a ContainerExited event
+// for a container whose exit has already been detected using its pidfd.
+func (pw *PodmanWatcher) forwardCgroupEvent(cntrev engineclient.ContainerEvent, cntreventstream chan<- engineclient.ContainerEvent) {
+	if cntrev.Type == engineclient.ContainerExited && pw.earlyExits() && pw.pidfds.exitedEarly(cntrev.ID) {
+		return
+	}
+	pw.forwardEarly(cntrev, cntreventstream)
+}
+
+// refreshEvent returns a “start” container event for the container referenced
+// in the specified Podman event, so that the watcher (re)inspects the
+// container and thus picks up its current state. This is used in full
+// inventory mode, where containers don't leave the portfolio before they have
+// been removed.
+func (pw *PodmanWatcher) refreshEvent(ctx context.Context, ev *entities.Event) (engineclient.ContainerEvent, bool) {
+	if pw.selector != nil || pw.inframode != InfraShow {
+		// As these events don't necessarily carry container labels, we need
+		// to inspect the container in order to decide whether it is of
+		// interest. We deliberately don't use our own Inspect here, as that
+		// would start tracking early exits, exec sessions, et cetera, of
+		// containers that might turn out to be not of interest.
+		details, err := containers.Inspect(ctx, ev.Actor.ID, nil)
+		if err != nil ||
+			(pw.selector != nil && !pw.selector.matchesDetails(ctx, pw, details)) ||
+			(pw.inframode != InfraShow && details.IsInfra) {
+			return engineclient.ContainerEvent{}, false
+		}
+	}
+	return engineclient.ContainerEvent{
+		Type:    engineclient.ContainerStarted,
+		ID:      ev.Actor.ID,
+		Project: ev.Actor.Attributes[moby.ComposerProjectLabel],
+	}, true
+}
@@ -0,0 +1,95 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"context"
+
+	"github.com/containers/podman/v4/pkg/domain/entities"
+	"github.com/docker/docker/api/types/events"
+	"github.com/thediveo/whalewatcher/engineclient"
+	"github.com/thediveo/whalewatcher/engineclient/moby"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func newEvent(action string, id string) *entities.Event {
+	return &entities.Event{
+		Message: events.Message{
+			Type:   "container",
+			Action: action,
+			Actor: events.Actor{
+				ID:         id,
+				Attributes: map[string]string{moby.ComposerProjectLabel: "testproject"},
+			},
+		},
+	}
+}
+
+var _ = Describe("podman events", func() {
+
+	var ctx context.Context
+
+	BeforeEach(func() {
+		ctx = context.Background()
+	})
+
+	It("subscribes to the required event statuses", func() {
+		pw := &PodmanWatcher{}
+		Expect(pw.eventStatuses()).To(ConsistOf(
+			"start", "died", "pause", "unpause", "checkpoint", "restore"))
+		pw.inventory = true
+		Expect(pw.eventStatuses()).To(ConsistOf(
+			"start", "died", "pause", "unpause", "checkpoint", "restore",
+			"create", "init", "cleanup", "remove"))
+		pw = &PodmanWatcher{execs: &execSessions{}}
+		Expect(pw.eventStatuses()).To(ConsistOf(
+			"start", "died", "pause", "unpause", "checkpoint", "restore",
+			"exec", "exec_died"))
+	})
+
+	DescribeTable("translating events",
+		func(inventory bool, action string, evtype engineclient.ContainerEventType, ok bool) {
+			pw := &PodmanWatcher{inventory: inventory}
+			ev, translated := pw.translateEvent(ctx, newEvent(action, "1234"))
+			Expect(translated).To(Equal(ok))
+			if !ok {
+				return
+			}
+			Expect(ev).To(Equal(engineclient.ContainerEvent{
+				Type:    evtype,
+				ID:      "1234",
+				Project: "testproject",
+			}))
+		},
+		Entry(nil, false, "start", engineclient.ContainerStarted, true),
+		Entry(nil, false, "died", engineclient.ContainerExited, true),
+		Entry(nil, false, "pause", engineclient.ContainerPaused, true),
+		Entry(nil, false, "unpause", engineclient.ContainerUnpaused, true),
+		Entry(nil, false, "create", nil, false),
+		Entry(nil, false, "remove", nil, false),
+		Entry(nil, false, "exec", nil, false),
+
+		Entry(nil, true, "start", engineclient.ContainerStarted, true),
+		Entry(nil, true, "create", engineclient.ContainerStarted, true),
+		Entry(nil, true, "init", engineclient.ContainerStarted, true),
+		Entry(nil, true, "died", engineclient.ContainerStarted, true),
+		Entry(nil, true, "cleanup", engineclient.ContainerStarted, true),
+		Entry(nil, true, "remove", engineclient.ContainerExited, true),
+		Entry(nil, true, "pause", engineclient.ContainerPaused, true),
+	)
+
+})
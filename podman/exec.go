@@ -0,0 +1,152 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/containers/podman/v4/libpod/define"
+	"github.com/containers/podman/v4/pkg/bindings/containers"
+)
+
+// ExecSession describes an active exec session in a container, such as
+// created by "podman exec".
+type ExecSession struct {
+	ID          string   // exec session ID.
+	ContainerID string   // ID of the container the session is executing in.
+	Command     []string // command and its arguments.
+	PID         int      // PID of the exec'ed process.
+	User        string   // user the command runs as, if specified.
+	Privileged  bool     // true if the session runs privileged.
+	Tty         bool     // true if the session has a TTY allocated.
+}
+
+// WithExecSessionTracking keeps track of the currently active exec sessions
+// per container, based on Podman's “exec” and “exec_died” container events.
+// Use [PodmanWatcher.ExecSessions] and [PodmanWatcher.AllExecSessions] to
+// query the active exec sessions.
+func WithExecSessionTracking() NewOption {
+	return func(pw *PodmanWatcher) {
+		pw.execs = &execSessions{sessions: map[string]map[string]ExecSession{}}
+	}
+}
+
+// execSessions keeps track of the active exec sessions of containers.
+type execSessions struct {
+	mu       sync.RWMutex
+	sessions map[string]map[string]ExecSession // container ID -> session ID -> session
+}
+
+// ExecSessions returns the currently active exec sessions of the container
+// with the specified ID, sorted by session ID. It returns nil if there are no
+// active exec sessions or exec session tracking isn't enabled using
+// [WithExecSessionTracking].
+func (pw *PodmanWatcher) ExecSessions(id string) []ExecSession {
+	if pw.execs == nil {
+		return nil
+	}
+	pw.execs.mu.RLock()
+	defer pw.execs.mu.RUnlock()
+	return sortedExecSessions(pw.execs.sessions[id])
+}
+
+// AllExecSessions returns the currently active exec sessions of all
+// containers, indexed by container ID. It returns nil if exec session tracking
+// isn't enabled using [WithExecSessionTracking].
+func (pw *PodmanWatcher) AllExecSessions() map[string][]ExecSession {
+	if pw.execs == nil {
+		return nil
+	}
+	pw.execs.mu.RLock()
+	defer pw.execs.mu.RUnlock()
+	all := make(map[string][]ExecSession, len(pw.execs.sessions))
+	for id, sessions := range pw.execs.sessions {
+		all[id] = sortedExecSessions(sessions)
+	}
+	return all
+}
+
+// sortedExecSessions returns the specified exec sessions sorted by session ID.
+func sortedExecSessions(sessions map[string]ExecSession) []ExecSession {
+	if len(sessions) == 0 {
+		return nil
+	}
+	s := make([]ExecSession, 0, len(sessions))
+	for _, session := range sessions {
+		s = append(s, session)
+	}
+	sort.Slice(s, func(i, j int) bool { return s[i].ID < s[j].ID })
+	return s
+}
+
+// refreshExecSessions updates the active exec sessions of the container with
+// the specified ID, as a consequence of an exec-related event. As Podman's
+// exec events don't reliably tell the exec session IDs, the container needs
+// to be inspected for its exec sessions.
+func (pw *PodmanWatcher) refreshExecSessions(ctx context.Context, id string) {
+	details, err := containers.Inspect(ctx, id, nil)
+	if err != nil {
+		pw.forgetExecSessions(id)
+		return
+	}
+	pw.updateExecSessions(ctx, details)
+}
+
+// updateExecSessions updates the active exec sessions of a container, given
+// its inspection data.
+func (pw *PodmanWatcher) updateExecSessions(ctx context.Context, details *define.InspectContainerData) {
+	sessions := map[string]ExecSession{}
+	for _, sessionid := range details.ExecIDs {
+		session, err := containers.ExecInspect(ctx, sessionid, nil)
+		if err != nil || !session.Running {
+			continue
+		}
+		sessions[sessionid] = newExecSession(session)
+	}
+	pw.execs.mu.Lock()
+	defer pw.execs.mu.Unlock()
+	if len(sessions) == 0 {
+		delete(pw.execs.sessions, details.ID)
+		return
+	}
+	pw.execs.sessions[details.ID] = sessions
+}
+
+// forgetExecSessions forgets all exec sessions of the container with the
+// specified ID, such as when the container has died.
+func (pw *PodmanWatcher) forgetExecSessions(id string) {
+	pw.execs.mu.Lock()
+	defer pw.execs.mu.Unlock()
+	delete(pw.execs.sessions, id)
+}
+
+// newExecSession returns the exec session information for the specified exec
+// session inspection data.
+func newExecSession(session *define.InspectExecSession) ExecSession {
+	s := ExecSession{
+		ID:          session.ID,
+		ContainerID: session.ContainerID,
+		PID:         session.Pid,
+	}
+	if proc := session.ProcessConfig; proc != nil {
+		s.Command = append([]string{proc.Entrypoint}, proc.Arguments...)
+		s.User = proc.User
+		s.Privileged = proc.Privileged
+		s.Tty = proc.Tty
+	}
+	return s
+}
@@ -0,0 +1,78 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"context"
+
+	"github.com/containers/podman/v4/libpod/define"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("exec sessions", func() {
+
+	It("doesn't report exec sessions when not tracking", func() {
+		pw := &PodmanWatcher{}
+		Expect(pw.ExecSessions("1234")).To(BeNil())
+		Expect(pw.AllExecSessions()).To(BeNil())
+	})
+
+	It("converts exec session inspection data", func() {
+		Expect(newExecSession(&define.InspectExecSession{
+			ID:          "abc",
+			ContainerID: "1234",
+			Pid:         42,
+			ProcessConfig: &define.InspectExecProcess{
+				Entrypoint: "/bin/sh",
+				Arguments:  []string{"-c", "sleep 1"},
+				User:       "nobody",
+				Tty:        true,
+			},
+		})).To(Equal(ExecSession{
+			ID:          "abc",
+			ContainerID: "1234",
+			Command:     []string{"/bin/sh", "-c", "sleep 1"},
+			PID:         42,
+			User:        "nobody",
+			Tty:         true,
+		}))
+		Expect(newExecSession(&define.InspectExecSession{ID: "abc"}).Command).To(BeNil())
+	})
+
+	It("reports and forgets exec sessions", func() {
+		pw := &PodmanWatcher{}
+		WithExecSessionTracking()(pw)
+		pw.execs.sessions["1234"] = map[string]ExecSession{
+			"def": {ID: "def", ContainerID: "1234"},
+			"abc": {ID: "abc", ContainerID: "1234"},
+		}
+		pw.execs.sessions["5678"] = map[string]ExecSession{
+			"ghi": {ID: "ghi", ContainerID: "5678"},
+		}
+		Expect(pw.ExecSessions("1234")).To(HaveExactElements(
+			HaveField("ID", "abc"), HaveField("ID", "def")))
+		Expect(pw.ExecSessions("0000")).To(BeNil())
+		Expect(pw.AllExecSessions()).To(HaveLen(2))
+
+		ev, ok := pw.translateEvent(context.Background(), newEvent("died", "1234"))
+		Expect(ok).To(BeTrue())
+		Expect(ev.ID).To(Equal("1234"))
+		Expect(pw.ExecSessions("1234")).To(BeNil())
+		Expect(pw.AllExecSessions()).To(HaveKey("5678"))
+	})
+
+})
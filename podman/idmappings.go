@@ -0,0 +1,199 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/containers/podman/v4/libpod/define"
+	"github.com/thediveo/whalewatcher"
+)
+
+// IDMap is a single contiguous range of user or group IDs inside a container's
+// user namespace that maps onto a range of IDs in the parent user namespace.
+type IDMap struct {
+	ContainerID uint32 // first ID inside the container's user namespace.
+	HostID      uint32 // first ID in the parent user namespace.
+	Size        uint32 // number of IDs in this range.
+}
+
+// String returns the ID range in the same "container:host:size" textual form
+// as used by Podman's container inspection data.
+func (m IDMap) String() string {
+	return fmt.Sprintf("%d:%d:%d", m.ContainerID, m.HostID, m.Size)
+}
+
+// IDMappings describes the UID and GID mappings of a container running in its
+// own user namespace.
+type IDMappings struct {
+	UIDs []IDMap
+	GIDs []IDMap
+}
+
+// ContainerIDMappings returns the UID and GID mappings of the specified
+// container, as attached to the container in form of the [UIDMapLabelName] and
+// [GIDMapLabelName] annotation labels. It returns nil if the container isn't
+// annotated with any ID mappings, either because it doesn't run in its own user
+// namespace or because [WithIDMappings] wasn't specified.
+func ContainerIDMappings(cntr *whalewatcher.Container) (*IDMappings, error) {
+	uidmap, hasuids := cntr.Labels[UIDMapLabelName]
+	gidmap, hasgids := cntr.Labels[GIDMapLabelName]
+	if !hasuids && !hasgids {
+		return nil, nil
+	}
+	uids, err := ParseIDMaps(uidmap)
+	if err != nil {
+		return nil, fmt.Errorf("invalid UID mapping annotation, %w", err)
+	}
+	gids, err := ParseIDMaps(gidmap)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GID mapping annotation, %w", err)
+	}
+	return &IDMappings{UIDs: uids, GIDs: gids}, nil
+}
+
+// ParseIDMaps parses the textual representation of ID mappings used in the
+// [UIDMapLabelName] and [GIDMapLabelName] annotation labels: a comma-separated
+// list of "container:host:size" ID ranges.
+func ParseIDMaps(s string) ([]IDMap, error) {
+	if s == "" {
+		return nil, nil
+	}
+	fields := strings.Split(s, ",")
+	idmaps := make([]IDMap, 0, len(fields))
+	for _, field := range fields {
+		idmap, err := parseIDMap(strings.Split(field, ":"))
+		if err != nil {
+			return nil, err
+		}
+		idmaps = append(idmaps, idmap)
+	}
+	return idmaps, nil
+}
+
+// formatIDMaps returns the textual representation of the specified ID
+// mappings, as used in the ID mapping annotation labels.
+func formatIDMaps(idmaps []IDMap) string {
+	s := make([]string, 0, len(idmaps))
+	for _, idmap := range idmaps {
+		s = append(s, idmap.String())
+	}
+	return strings.Join(s, ",")
+}
+
+// parseIDMap parses the three fields of an ID range (container ID, host ID,
+// and size), independent of how they originally were separated.
+func parseIDMap(fields []string) (IDMap, error) {
+	if len(fields) != 3 {
+		return IDMap{}, fmt.Errorf("malformed ID range %q", strings.Join(fields, ":"))
+	}
+	var ids [3]uint32
+	for idx, field := range fields {
+		id, err := strconv.ParseUint(field, 10, 32)
+		if err != nil {
+			return IDMap{}, fmt.Errorf("malformed ID range %q, %w", strings.Join(fields, ":"), err)
+		}
+		ids[idx] = uint32(id)
+	}
+	return IDMap{ContainerID: ids[0], HostID: ids[1], Size: ids[2]}, nil
+}
+
+// inspectIDMappings returns the ID mappings from a container's inspection
+// data, if present. Please note that Podman reports ID mappings only in case
+// of explicitly configured mappings, such as when using "--userns", so this
+// will miss out on containers of rootless Podman engines.
+func inspectIDMappings(details *define.InspectContainerData) (*IDMappings, error) {
+	if details.HostConfig == nil || details.HostConfig.IDMappings == nil {
+		return nil, nil
+	}
+	idmappings := &IDMappings{}
+	for _, uidmap := range details.HostConfig.IDMappings.UIDMap {
+		idmap, err := parseIDMap(strings.Split(uidmap, ":"))
+		if err != nil {
+			return nil, err
+		}
+		idmappings.UIDs = append(idmappings.UIDs, idmap)
+	}
+	for _, gidmap := range details.HostConfig.IDMappings.GIDMap {
+		idmap, err := parseIDMap(strings.Split(gidmap, ":"))
+		if err != nil {
+			return nil, err
+		}
+		idmappings.GIDs = append(idmappings.GIDs, idmap)
+	}
+	if len(idmappings.UIDs) == 0 && len(idmappings.GIDs) == 0 {
+		return nil, nil
+	}
+	return idmappings, nil
+}
+
+// procIDMappings returns the ID mappings of the process with the specified
+// PID, reading them from the proc filesystem mounted at procroot (usually
+// "/proc"). It returns nil if the process isn't in a user namespace different
+// from the initial user namespace, that is, if its ID mappings are the identity
+// mappings.
+func procIDMappings(procroot string, pid int) (*IDMappings, error) {
+	uids, err := readIDMapFile(fmt.Sprintf("%s/%d/uid_map", procroot, pid))
+	if err != nil {
+		return nil, err
+	}
+	gids, err := readIDMapFile(fmt.Sprintf("%s/%d/gid_map", procroot, pid))
+	if err != nil {
+		return nil, err
+	}
+	if isIdentityIDMap(uids) && isIdentityIDMap(gids) {
+		return nil, nil
+	}
+	return &IDMappings{UIDs: uids, GIDs: gids}, nil
+}
+
+// readIDMapFile reads a uid_map or gid_map file in the format documented in
+// user_namespaces(7).
+func readIDMapFile(name string) ([]IDMap, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	idmaps := []IDMap{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		idmap, err := parseIDMap(fields)
+		if err != nil {
+			return nil, err
+		}
+		idmaps = append(idmaps, idmap)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return idmaps, nil
+}
+
+// isIdentityIDMap returns true if the specified ID mappings are the identity
+// mappings of the initial user namespace.
+func isIdentityIDMap(idmaps []IDMap) bool {
+	return len(idmaps) == 1 &&
+		idmaps[0].ContainerID == 0 && idmaps[0].HostID == 0 && idmaps[0].Size == math.MaxUint32
+}
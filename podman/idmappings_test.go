@@ -0,0 +1,106 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/containers/podman/v4/libpod/define"
+	"github.com/thediveo/whalewatcher"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("user namespace ID mappings", func() {
+
+	It("parses ID mapping annotations", func() {
+		Expect(ParseIDMaps("")).To(BeEmpty())
+		Expect(ParseIDMaps("0:1000:1,1:100000:65536")).To(ConsistOf(
+			IDMap{ContainerID: 0, HostID: 1000, Size: 1},
+			IDMap{ContainerID: 1, HostID: 100000, Size: 65536},
+		))
+		Expect(ParseIDMaps("0:1000")).Error().To(HaveOccurred())
+		Expect(ParseIDMaps("0:1000:-1")).Error().To(HaveOccurred())
+	})
+
+	It("round-trips ID mappings via container labels", func() {
+		idmaps := []IDMap{{ContainerID: 0, HostID: 1000, Size: 1}, {ContainerID: 1, HostID: 100000, Size: 65536}}
+		cntr := &whalewatcher.Container{Labels: map[string]string{
+			UIDMapLabelName: formatIDMaps(idmaps),
+			GIDMapLabelName: formatIDMaps(idmaps[:1]),
+		}}
+		Expect(ContainerIDMappings(cntr)).To(Equal(&IDMappings{
+			UIDs: idmaps,
+			GIDs: idmaps[:1],
+		}))
+
+		Expect(ContainerIDMappings(&whalewatcher.Container{})).To(BeNil())
+
+		cntr.Labels[GIDMapLabelName] = "foo"
+		Expect(ContainerIDMappings(cntr)).Error().To(HaveOccurred())
+	})
+
+	It("gets ID mappings from inspection data", func() {
+		Expect(inspectIDMappings(&define.InspectContainerData{})).To(BeNil())
+		Expect(inspectIDMappings(&define.InspectContainerData{
+			HostConfig: &define.InspectContainerHostConfig{
+				IDMappings: &define.InspectIDMappings{},
+			},
+		})).To(BeNil())
+		Expect(inspectIDMappings(&define.InspectContainerData{
+			HostConfig: &define.InspectContainerHostConfig{
+				IDMappings: &define.InspectIDMappings{
+					UIDMap: []string{"0:1000:1"},
+					GIDMap: []string{"0:2000:1", "1:3000:42"},
+				},
+			},
+		})).To(Equal(&IDMappings{
+			UIDs: []IDMap{{ContainerID: 0, HostID: 1000, Size: 1}},
+			GIDs: []IDMap{{ContainerID: 0, HostID: 2000, Size: 1}, {ContainerID: 1, HostID: 3000, Size: 42}},
+		}))
+		Expect(inspectIDMappings(&define.InspectContainerData{
+			HostConfig: &define.InspectContainerHostConfig{
+				IDMappings: &define.InspectIDMappings{
+					UIDMap: []string{"0:1000"},
+				},
+			},
+		})).Error().To(HaveOccurred())
+	})
+
+	It("reads ID mappings from procfs", func() {
+		procroot := GinkgoT().TempDir()
+		writeIDMaps := func(pid string, uidmap, gidmap string) {
+			Expect(os.Mkdir(filepath.Join(procroot, pid), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(procroot, pid, "uid_map"), []byte(uidmap), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(procroot, pid, "gid_map"), []byte(gidmap), 0644)).To(Succeed())
+		}
+		writeIDMaps("1", "         0          0 4294967295\n", "         0          0 4294967295\n")
+		writeIDMaps("42",
+			"         0       1000          1\n         1     100000      65536\n",
+			"         0       1000          1\n")
+		writeIDMaps("666", "0 0\n", "")
+
+		Expect(procIDMappings(procroot, 1)).To(BeNil())
+		Expect(procIDMappings(procroot, 42)).To(Equal(&IDMappings{
+			UIDs: []IDMap{{ContainerID: 0, HostID: 1000, Size: 1}, {ContainerID: 1, HostID: 100000, Size: 65536}},
+			GIDs: []IDMap{{ContainerID: 0, HostID: 1000, Size: 1}},
+		}))
+		Expect(procIDMappings(procroot, 666)).Error().To(HaveOccurred())
+		Expect(procIDMappings(procroot, 12345)).Error().To(HaveOccurred())
+	})
+
+})
@@ -0,0 +1,114 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"context"
+	"strings"
+
+	"github.com/containers/podman/v4/libpod/define"
+	"github.com/containers/podman/v4/pkg/bindings/images"
+	"github.com/jellydator/ttlcache/v3"
+	"github.com/thediveo/whalewatcher"
+)
+
+// imageIdentity contains the identifying information about an image, as
+// reported by the libpod image inspect endpoint.
+type imageIdentity struct {
+	repoTags    []string
+	repoDigests []string
+	platform    string // os/arch
+}
+
+// annotateImage adds the image identity annotation labels to the specified
+// container. The image ID and image name are always taken from the container
+// details, while the repo digest and platform need an image inspection.
+func (pw *PodmanWatcher) annotateImage(ctx context.Context, cntr *whalewatcher.Container, details *define.InspectContainerData) {
+	if details.Image == "" {
+		return
+	}
+	cntr.Labels[ImageIDLabelName] = details.Image
+	name := details.ImageName
+	identity := pw.imageIdentity(ctx, details.Image)
+	if identity == nil {
+		if name != "" {
+			cntr.Labels[ImageNameLabelName] = name
+		}
+		return
+	}
+	if name == "" && len(identity.repoTags) > 0 {
+		name = identity.repoTags[0]
+	}
+	if name != "" {
+		cntr.Labels[ImageNameLabelName] = name
+	}
+	if digest := repoDigest(name, identity.repoDigests); digest != "" {
+		cntr.Labels[ImageDigestLabelName] = digest
+	}
+	if identity.platform != "" {
+		cntr.Labels[ImagePlatformLabelName] = identity.platform
+	}
+}
+
+// imageIdentity returns the identifying information about the image with the
+// specified ID, or nil if the image couldn't be inspected. As many containers
+// usually share the same image, the image information gets cached.
+func (pw *PodmanWatcher) imageIdentity(ctx context.Context, imageid string) *imageIdentity {
+	if identity := pw.imagecache.Get(imageid); identity != nil {
+		return identity.Value()
+	}
+	imagedetails, err := images.GetImage(ctx, imageid, nil)
+	if err != nil || imagedetails.ImageData == nil {
+		// We don't do negative caching here.
+		return nil
+	}
+	identity := &imageIdentity{
+		repoTags:    imagedetails.RepoTags,
+		repoDigests: imagedetails.RepoDigests,
+	}
+	if imagedetails.Os != "" && imagedetails.Architecture != "" {
+		identity.platform = imagedetails.Os + "/" + imagedetails.Architecture
+	}
+	pw.imagecache.Set(imageid, identity, ttlcache.DefaultTTL)
+	return identity
+}
+
+// repoDigest returns the repo digest (in "repo@digest" form) matching the
+// repository of the specified image name. If there is no matching repository,
+// then repoDigest returns "", as repo digests of other repositories might refer
+// to different images.
+func repoDigest(name string, repodigests []string) string {
+	repo := imageRepository(name)
+	for _, repodigest := range repodigests {
+		if r, _, ok := strings.Cut(repodigest, "@"); ok && r == repo {
+			return repodigest
+		}
+	}
+	return ""
+}
+
+// imageRepository returns the repository part of an image reference, that is,
+// without any tag or digest.
+func imageRepository(name string) string {
+	if repo, _, ok := strings.Cut(name, "@"); ok {
+		return repo
+	}
+	// Only a colon after the last slash separates a tag; otherwise, it is
+	// the port of the registry host.
+	if colon := strings.LastIndex(name, ":"); colon > strings.LastIndex(name, "/") {
+		return name[:colon]
+	}
+	return name
+}
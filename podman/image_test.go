@@ -0,0 +1,75 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"context"
+
+	"github.com/containers/podman/v4/libpod/define"
+	"github.com/jellydator/ttlcache/v3"
+	"github.com/thediveo/whalewatcher"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("image identity", func() {
+
+	DescribeTable("image repositories",
+		func(name, repo string) {
+			Expect(imageRepository(name)).To(Equal(repo))
+		},
+		Entry(nil, "busybox", "busybox"),
+		Entry(nil, "docker.io/library/busybox:latest", "docker.io/library/busybox"),
+		Entry(nil, "localhost:5000/foo", "localhost:5000/foo"),
+		Entry(nil, "localhost:5000/foo:bar", "localhost:5000/foo"),
+		Entry(nil, "quay.io/foo/bar@sha256:1234", "quay.io/foo/bar"),
+	)
+
+	It("picks the matching repo digest", func() {
+		repodigests := []string{
+			"quay.io/foo/busybox@sha256:1234",
+			"docker.io/library/busybox@sha256:5678",
+		}
+		Expect(repoDigest("docker.io/library/busybox:latest", nil)).To(BeEmpty())
+		Expect(repoDigest("docker.io/library/busybox:latest", repodigests)).To(
+			Equal("docker.io/library/busybox@sha256:5678"))
+	})
+
+	It("doesn't pick repo digests of other repositories", func() {
+		Expect(repoDigest("example.org/busybox", []string{
+			"quay.io/foo/busybox@sha256:1234",
+			"docker.io/library/busybox@sha256:5678",
+		})).To(BeEmpty())
+		Expect(repoDigest("example.org/busybox", []string{"sha256:1234"})).To(BeEmpty())
+	})
+
+	It("doesn't annotate images without repo digests with a digest", func() {
+		pw := NewPodmanWatcher(context.Background(), WithImageAnnotations())
+		defer pw.Close()
+		pw.imagecache.Set("deadbeef", &imageIdentity{
+			repoTags: []string{"localhost/busybox:latest"},
+			platform: "linux/amd64",
+		}, ttlcache.DefaultTTL)
+		cntr := &whalewatcher.Container{Labels: map[string]string{}}
+		pw.annotateImage(context.Background(), cntr, &define.InspectContainerData{Image: "deadbeef"})
+		Expect(cntr.Labels).To(And(
+			HaveKeyWithValue(ImageIDLabelName, "deadbeef"),
+			HaveKeyWithValue(ImageNameLabelName, "localhost/busybox:latest"),
+			HaveKeyWithValue(ImagePlatformLabelName, "linux/amd64"),
+			Not(HaveKey(ImageDigestLabelName))))
+	})
+
+})
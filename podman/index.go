@@ -0,0 +1,238 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thediveo/whalewatcher"
+	"github.com/thediveo/whalewatcher/watcher"
+)
+
+// Owner is the container a process or cgroup belongs to, together with the
+// container's pod (if any) and the engine (watcher) managing the container.
+type Owner struct {
+	Container *whalewatcher.Container
+	PodName   string // empty if the container doesn't belong to a pod.
+	PodID     string // empty if the container doesn't belong to a pod.
+	Engine    watcher.Watcher
+}
+
+// ContainerIndex looks up the containers that arbitrary processes or cgroups
+// belong to, across the containers of multiple watchers. The index is
+// maintained from the watchers' container portfolios; it gets rebuilt
+// automatically whenever a lookup misses the current index, but not more
+// often than every [IndexRefreshInterval]. Use [ContainerIndex.Refresh] to
+// force rebuilding the index.
+//
+// Processes get resolved to containers using their cgroup memberships and,
+// failing that, their ancestry, so that not only the initial container
+// processes, but also all other processes inside containers can be resolved.
+type ContainerIndex struct {
+	procroot string
+
+	mu       sync.RWMutex
+	watchers []watcher.Watcher
+	byID     map[string]Owner // container ID -> owner
+	byPID    map[int]Owner    // initial container process PID -> owner
+	built    time.Time        // when the index was last built.
+}
+
+// IndexRefreshInterval is the minimum interval between automatic rebuilds of
+// a [ContainerIndex] due to lookup misses.
+const IndexRefreshInterval = time.Second
+
+// libpodScopeRegexp matches the cgroup path elements of libpod-managed
+// containers, such as "libpod-<ID>.scope" for the systemd cgroup manager and
+// "libpod-<ID>" for the cgroupfs cgroup manager. It doesn't match the
+// "libpod-conmon-<ID>.scope" cgroups of the conmon processes.
+var libpodScopeRegexp = regexp.MustCompile(`^libpod-([0-9a-f]{64})(?:\.scope)?$`)
+
+// NewContainerIndex returns a new container index for the specified watchers.
+func NewContainerIndex(watchers ...watcher.Watcher) *ContainerIndex {
+	return &ContainerIndex{
+		procroot: "/proc",
+		watchers: watchers,
+	}
+}
+
+// Add the specified watcher to the index.
+func (x *ContainerIndex) Add(w watcher.Watcher) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.watchers = append(x.watchers, w)
+	x.byID = nil
+	x.byPID = nil
+}
+
+// Refresh rebuilds the index from the current container portfolios of the
+// watchers.
+func (x *ContainerIndex) Refresh() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.refreshUnderLock()
+}
+
+// refreshUnderLock rebuilds the index; it must be called with the write lock
+// held.
+func (x *ContainerIndex) refreshUnderLock() {
+	x.byID = map[string]Owner{}
+	x.byPID = map[int]Owner{}
+	x.built = time.Now()
+	for _, w := range x.watchers {
+		portfolio := w.Portfolio()
+		if portfolio == nil {
+			continue
+		}
+		for _, name := range portfolio.Names() {
+			for _, cntr := range portfolio.Project(name).Containers() {
+				owner := Owner{
+					Container: cntr,
+					PodName:   cntr.Labels[PodLabelName],
+					PodID:     cntr.Labels[PodIDName],
+					Engine:    w,
+				}
+				x.byID[cntr.ID] = owner
+				if cntr.PID != 0 {
+					x.byPID[cntr.PID] = owner
+				}
+			}
+		}
+	}
+}
+
+// lookup returns the owner found by the specified lookup function, rebuilding
+// the index once in case of a miss, unless the index has been built only
+// recently.
+func (x *ContainerIndex) lookup(fn func() (Owner, bool)) (Owner, bool) {
+	x.mu.RLock()
+	if x.byID != nil {
+		if owner, ok := fn(); ok {
+			x.mu.RUnlock()
+			return owner, true
+		}
+	}
+	x.mu.RUnlock()
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.byID == nil || time.Since(x.built) >= IndexRefreshInterval {
+		x.refreshUnderLock()
+	}
+	return fn()
+}
+
+// LookupContainerID returns the owner for the container with the specified
+// (full) ID, if known.
+func (x *ContainerIndex) LookupContainerID(id string) (Owner, bool) {
+	return x.lookup(func() (Owner, bool) {
+		owner, ok := x.byID[id]
+		return owner, ok
+	})
+}
+
+// LookupCgroup returns the owner of the specified cgroup path, if it belongs
+// to a known container. The path might be either a container's cgroup or a
+// child cgroup of it.
+func (x *ContainerIndex) LookupCgroup(cgrouppath string) (Owner, bool) {
+	id := cgroupContainerID(cgrouppath)
+	if id == "" {
+		return Owner{}, false
+	}
+	return x.LookupContainerID(id)
+}
+
+// LookupPID returns the owner of the process with the specified PID, if the
+// process belongs to a known container. The process can be any process inside
+// a container, not only its initial process. The PID must be from the PID
+// namespace of the proc filesystem used by the index.
+func (x *ContainerIndex) LookupPID(pid int) (Owner, bool) {
+	if id := procContainerID(x.procroot, pid); id != "" {
+		if owner, ok := x.LookupContainerID(id); ok {
+			return owner, true
+		}
+	}
+	// Fall back to the process ancestry, looking for the initial process of a
+	// known container.
+	ancestors := procAncestors(x.procroot, pid)
+	return x.lookup(func() (Owner, bool) {
+		for _, ancestor := range ancestors {
+			if owner, ok := x.byPID[ancestor]; ok {
+				return owner, true
+			}
+		}
+		return Owner{}, false
+	})
+}
+
+// cgroupContainerID returns the ID of the libpod container the specified
+// cgroup path belongs to, or "" if the path doesn't belong to a libpod
+// container.
+func cgroupContainerID(cgrouppath string) string {
+	elements := strings.Split(cgrouppath, "/")
+	for idx := len(elements) - 1; idx >= 0; idx-- {
+		if m := libpodScopeRegexp.FindStringSubmatch(elements[idx]); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// procContainerID returns the ID of the libpod container the process with the
+// specified PID belongs to according to its cgroup memberships, or "" if the
+// process doesn't belong to a libpod container.
+func procContainerID(procroot string, pid int) string {
+	f, err := os.Open(procroot + "/" + strconv.Itoa(pid) + "/cgroup")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		if id := cgroupContainerID(fields[2]); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// procAncestors returns the PID of the specified process, followed by the
+// PIDs of its ancestors, up to (but excluding) the initial process of the
+// PID namespace.
+func procAncestors(procroot string, pid int) []int {
+	var ancestors []int
+	for pid > 1 && len(ancestors) < 1024 {
+		ancestors = append(ancestors, pid)
+		stat, err := os.ReadFile(procroot + "/" + strconv.Itoa(pid) + "/stat")
+		if err != nil {
+			break
+		}
+		proc, err := parseProcStat(stat)
+		if err != nil {
+			break
+		}
+		pid = proc.ppid
+	}
+	return ancestors
+}
@@ -0,0 +1,135 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/thediveo/whalewatcher"
+	"github.com/thediveo/whalewatcher/watcher"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// fakeWatcher is a watcher with a fixed container portfolio.
+type fakeWatcher struct {
+	watcher.Watcher
+	id        string
+	portfolio *whalewatcher.Portfolio
+}
+
+func (w *fakeWatcher) Portfolio() *whalewatcher.Portfolio { return w.portfolio }
+func (w *fakeWatcher) ID(context.Context) string          { return w.id }
+
+// newFakeWatcher returns a fake watcher with the specified containers.
+func newFakeWatcher(id string, cntrs ...*whalewatcher.Container) *fakeWatcher {
+	w := &fakeWatcher{id: id, portfolio: whalewatcher.NewPortfolio()}
+	for _, cntr := range cntrs {
+		w.portfolio.Add(cntr)
+	}
+	return w
+}
+
+// fakeCgroup sets the (unified hierarchy) cgroup of the process with the
+// specified PID in the fake procfs at procroot.
+func fakeCgroup(procroot string, pid int, cgroup string) {
+	GinkgoHelper()
+	Expect(os.WriteFile(filepath.Join(procroot, strconv.Itoa(pid), "cgroup"),
+		[]byte("0::"+cgroup+"\n"), 0644)).To(Succeed())
+}
+
+var _ = Describe("container index", func() {
+
+	id1 := strings.Repeat("1", 64)
+	id2 := strings.Repeat("2", 64)
+	id3 := strings.Repeat("3", 64)
+
+	DescribeTable("extracting container IDs from cgroup paths",
+		func(cgroup string, id string) {
+			Expect(cgroupContainerID(cgroup)).To(Equal(id))
+		},
+		Entry(nil, "/machine.slice/libpod-"+id1+".scope", id1),
+		Entry(nil, "/machine.slice/libpod-"+id1+".scope/container", id1),
+		Entry(nil, "/libpod_parent/libpod-"+id1, id1),
+		Entry(nil, "/user.slice/user-1000.slice/user@1000.service/user.slice/libpod-"+id1+".scope", id1),
+		Entry(nil, "/machine.slice/libpod-conmon-"+id1+".scope", ""),
+		Entry(nil, "/machine.slice/libpod-1234.scope", ""),
+		Entry(nil, "/", ""),
+	)
+
+	It("looks up containers across multiple watchers", func() {
+		procroot := GinkgoT().TempDir()
+		fakeProc(procroot, 1, 0, "systemd", "/sbin/init")
+		fakeCgroup(procroot, 1, "/init.scope")
+		// container with cgroup information.
+		fakeProc(procroot, 100, 1, "sleep", "sleep")
+		fakeCgroup(procroot, 100, "/machine.slice/libpod-"+id1+".scope/container")
+		fakeProc(procroot, 101, 100, "sh", "sh")
+		fakeCgroup(procroot, 101, "/machine.slice/libpod-"+id1+".scope/container")
+		// container without (useful) cgroup information.
+		fakeProc(procroot, 200, 1, "sleep", "sleep")
+		fakeProc(procroot, 201, 200, "sh", "sh")
+		fakeProc(procroot, 202, 201, "cat", "cat")
+		fakeCgroup(procroot, 202, "/")
+
+		w1 := newFakeWatcher("podman-1",
+			&whalewatcher.Container{ID: id1, Name: "foo", PID: 100, Labels: map[string]string{
+				PodLabelName: "pod", PodIDName: "abcd",
+			}})
+		w2 := newFakeWatcher("podman-2",
+			&whalewatcher.Container{ID: id2, Name: "bar", PID: 200})
+		x := NewContainerIndex(w1)
+		x.procroot = procroot
+		x.Add(w2)
+
+		owner, ok := x.LookupPID(101)
+		Expect(ok).To(BeTrue())
+		Expect(owner.Container.Name).To(Equal("foo"))
+		Expect(owner.PodName).To(Equal("pod"))
+		Expect(owner.PodID).To(Equal("abcd"))
+		Expect(owner.Engine).To(BeIdenticalTo(w1))
+
+		owner, ok = x.LookupPID(202)
+		Expect(ok).To(BeTrue())
+		Expect(owner.Container.Name).To(Equal("bar"))
+		Expect(owner.PodName).To(BeEmpty())
+		Expect(owner.Engine).To(BeIdenticalTo(w2))
+
+		_, ok = x.LookupPID(1)
+		Expect(ok).To(BeFalse())
+		_, ok = x.LookupPID(666)
+		Expect(ok).To(BeFalse())
+
+		owner, ok = x.LookupCgroup("/machine.slice/libpod-" + id2 + ".scope")
+		Expect(ok).To(BeTrue())
+		Expect(owner.Container.Name).To(Equal("bar"))
+		_, ok = x.LookupCgroup("/machine.slice/libpod-" + id3 + ".scope")
+		Expect(ok).To(BeFalse())
+		_, ok = x.LookupCgroup("/init.scope")
+		Expect(ok).To(BeFalse())
+
+		w2.portfolio.Add(&whalewatcher.Container{ID: id3, Name: "baz", PID: 300})
+		x.Refresh()
+		owner, ok = x.LookupContainerID(id3)
+		Expect(ok).To(BeTrue())
+		Expect(owner.Container.Name).To(Equal("baz"))
+	})
+
+})
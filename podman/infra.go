@@ -0,0 +1,135 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/containers/podman/v4/pkg/bindings/containers"
+	"github.com/containers/podman/v4/pkg/bindings/pods"
+	"github.com/jellydator/ttlcache/v3"
+	"github.com/thediveo/whalewatcher"
+)
+
+// InfraMode specifies how to handle the “infrastructure” (pause) containers of
+// pods.
+type InfraMode int
+
+// How to handle infra containers.
+const (
+	InfraShow InfraMode = iota // list infra containers as ordinary containers (default).
+	InfraHide                  // exclude infra containers from List and lifecycle events.
+	InfraFold                  // exclude infra containers, but annotate pod members with infra details.
+)
+
+// WithInfraMode sets how to handle the infra containers of pods. By default,
+// infra containers are treated as ordinary containers, only marked using the
+// [InfraLabelName] label.
+//
+// With [InfraHide], infra containers are excluded from List and lifecycle
+// events. With [InfraFold], infra containers are excluded too, but their
+// details get folded into their pod's member containers, using the
+// [InfraIDLabelName], [InfraPIDLabelName], and [InfraNetNSLabelName] labels.
+// Please note that explicitly inspecting an infra container still works
+// regardless of the infra mode.
+func WithInfraMode(mode InfraMode) NewOption {
+	return func(pw *PodmanWatcher) {
+		pw.inframode = mode
+	}
+}
+
+// isInfra returns true if the specified container is an infra container.
+func isInfra(cntr *whalewatcher.Container) bool {
+	_, ok := cntr.Labels[InfraLabelName]
+	return ok
+}
+
+// isInfraID returns true if the container with the specified ID is an infra
+// container. If the container cannot be inspected, such as when it has gone
+// already, isInfraID returns false.
+func (pw *PodmanWatcher) isInfraID(ctx context.Context, id string) bool {
+	details, err := containers.Inspect(ctx, id, nil)
+	return err == nil && details.IsInfra
+}
+
+// infraDetails caches the details of a pod's infra container that get folded
+// into the pod's member containers.
+type infraDetails struct {
+	id    string // infra container ID.
+	pid   int    // infra container PID, or zero if not running.
+	netns string // path of the infra container's network namespace.
+}
+
+// foldInfra annotates the specified pod member container with details of its
+// pod's infra container. If the pod has no infra container, or the infra
+// container cannot be inspected, then the container doesn't get annotated.
+func (pw *PodmanWatcher) foldInfra(ctx context.Context, cntr *whalewatcher.Container, podid string) {
+	infra := pw.infraDetails(ctx, podid)
+	if infra == nil {
+		return
+	}
+	cntr.Labels[InfraIDLabelName] = infra.id
+	if infra.pid != 0 {
+		cntr.Labels[InfraPIDLabelName] = strconv.Itoa(infra.pid)
+	}
+	if infra.netns != "" {
+		cntr.Labels[InfraNetNSLabelName] = infra.netns
+	}
+}
+
+// infraDetails returns the details of the infra container of the pod with the
+// specified ID, or nil if the pod has no infra container or the details cannot
+// be determined. Details of running infra containers are cached, so that
+// inspecting multiple pod members doesn't cost inspecting the pod and its
+// infra container each time.
+func (pw *PodmanWatcher) infraDetails(ctx context.Context, podid string) *infraDetails {
+	if pw.infracache != nil {
+		if item := pw.infracache.Get(podid); item != nil {
+			return item.Value()
+		}
+	}
+	poddetails, err := pods.Inspect(ctx, podid, nil)
+	if err != nil || poddetails.InspectPodData == nil || poddetails.InfraContainerID == "" {
+		// We don't do negative caching here.
+		return nil
+	}
+	details, err := containers.Inspect(ctx, poddetails.InfraContainerID, nil)
+	if err != nil {
+		return nil
+	}
+	infra := &infraDetails{id: details.ID}
+	if details.State != nil {
+		infra.pid = details.State.Pid
+	}
+	if details.NetworkSettings != nil {
+		infra.netns = details.NetworkSettings.SandboxKey
+	}
+	// Don't cache the details of an infra container that isn't running (yet),
+	// as its PID and network namespace are yet to come.
+	if pw.infracache != nil && infra.pid != 0 {
+		pw.infracache.Set(podid, infra, ttlcache.DefaultTTL)
+	}
+	return infra
+}
+
+// forgetInfraDetails removes any cached infra container details of the pod
+// with the specified ID, such as after one of its containers has died.
+func (pw *PodmanWatcher) forgetInfraDetails(podid string) {
+	if pw.infracache == nil || podid == "" {
+		return
+	}
+	pw.infracache.Delete(podid)
+}
@@ -0,0 +1,67 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"context"
+
+	"github.com/jellydator/ttlcache/v3"
+	"github.com/thediveo/whalewatcher"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("infra containers", func() {
+
+	It("identifies infra containers", func() {
+		Expect(isInfra(&whalewatcher.Container{})).To(BeFalse())
+		Expect(isInfra(&whalewatcher.Container{
+			Labels: map[string]string{InfraLabelName: ""},
+		})).To(BeTrue())
+	})
+
+	It("sets the infra mode", func() {
+		pw := NewPodmanWatcher(context.Background())
+		defer pw.Close()
+		Expect(pw.inframode).To(Equal(InfraShow))
+
+		pw = NewPodmanWatcher(context.Background(), WithInfraMode(InfraFold))
+		defer pw.Close()
+		Expect(pw.inframode).To(Equal(InfraFold))
+		Expect(pw.infracache).NotTo(BeNil())
+	})
+
+	It("folds cached infra details into pod members", func() {
+		pw := NewPodmanWatcher(context.Background(), WithInfraMode(InfraFold))
+		defer pw.Close()
+		pw.infracache.Set("pod", &infraDetails{
+			id:    "infra",
+			pid:   42,
+			netns: "/run/netns/foo",
+		}, ttlcache.DefaultTTL)
+
+		cntr := &whalewatcher.Container{Labels: map[string]string{}}
+		pw.foldInfra(context.Background(), cntr, "pod")
+		Expect(cntr.Labels).To(And(
+			HaveKeyWithValue(InfraIDLabelName, "infra"),
+			HaveKeyWithValue(InfraPIDLabelName, "42"),
+			HaveKeyWithValue(InfraNetNSLabelName, "/run/netns/foo")))
+
+		pw.forgetInfraDetails("pod")
+		Expect(pw.infracache.Get("pod")).To(BeNil())
+	})
+
+})
@@ -0,0 +1,37 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+// WithFullInventory tracks not only the alive containers, but also the
+// containers that have been created, but not (yet) started, as well as the
+// containers that have exited or been stopped. All containers then get
+// annotated with their explicit state using the [StateLabelName] label, such
+// as "created", "running", "paused", "exited", or "stopped".
+//
+// In full inventory mode, containers without any processes have a zero PID.
+// The lifecycle events then change their meaning: a [ContainerStarted] event
+// now signals that a container appeared or changed its state, and thus needs
+// to be (re)inspected, while a [ContainerExited] event signals that a
+// container has been removed. These lifecycle events are fed by Podman's
+// “create”, “init”, “start”, “died”, “cleanup”, and “remove” container
+// events.
+//
+// [ContainerStarted]: https://pkg.go.dev/github.com/thediveo/whalewatcher/engineclient#ContainerStarted
+// [ContainerExited]: https://pkg.go.dev/github.com/thediveo/whalewatcher/engineclient#ContainerExited
+func WithFullInventory() NewOption {
+	return func(pw *PodmanWatcher) {
+		pw.inventory = true
+	}
+}
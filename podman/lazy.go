@@ -0,0 +1,100 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"context"
+
+	"github.com/containers/podman/v4/pkg/bindings"
+	"github.com/thediveo/sealwatcher/v2/util"
+	"github.com/thediveo/whalewatcher/engineclient"
+)
+
+// WithLazyConnection defers connecting to the Podman API service at the
+// specified URI until the first [PodmanWatcher.Try], [PodmanWatcher.List], or
+// [PodmanWatcher.LifecycleEvents], so that creating a watcher succeeds even
+// while the Podman API service isn't available yet, such as during boot or
+// when podman.socket isn't active. Failing to connect then is handled by the
+// watcher's backoff, the same as any other failure to talk to the service.
+// Alternatively, use [WithSocketWaiting] to wait for the service's socket to
+// appear.
+//
+// Pass a nil connection to [NewPodmanWatcher] when using this option.
+func WithLazyConnection(podmansock string) NewOption {
+	return func(pw *PodmanWatcher) {
+		pw.lazysock = podmansock
+		pw.connect = func() (context.Context, error) {
+			return bindings.NewConnection(context.Background(), podmansock)
+		}
+	}
+}
+
+// conn returns the Podman connection context, or a context without any
+// Podman client as long as a lazy connection hasn't been established yet.
+func (pw *PodmanWatcher) conn() context.Context {
+	pw.cmu.RLock()
+	defer pw.cmu.RUnlock()
+	if pw.podman == nil {
+		return context.Background()
+	}
+	return pw.podman
+}
+
+// connected establishes a lazy connection to the Podman API service, unless
+// already connected, returning an error if connecting fails. When waiting for
+// the Podman API socket, connected first waits for the socket to become
+// reachable, or the specified context to get cancelled. Waiting doesn't
+// serialize concurrent callers, so that each caller's context is honored.
+func (pw *PodmanWatcher) connected(ctx context.Context) error {
+	if pw.isConnected() || pw.connect == nil {
+		return nil
+	}
+	if pw.waitsocket {
+		if _, ok, _ := unixSocketPath(pw.lazysock); ok {
+			if err := WaitForSocket(ctx, pw.lazysock); err != nil {
+				return classify(ctx, err)
+			}
+		}
+	}
+	pw.lmu.Lock()
+	defer pw.lmu.Unlock()
+	if pw.isConnected() {
+		return nil // someone else was faster.
+	}
+	podman, err := pw.connect()
+	if err != nil {
+		return util.Classify(err)
+	}
+	pw.cmu.Lock()
+	pw.podman = podman
+	pw.cmu.Unlock()
+	return nil
+}
+
+// isConnected returns true if there is a Podman connection.
+func (pw *PodmanWatcher) isConnected() bool {
+	pw.cmu.RLock()
+	defer pw.cmu.RUnlock()
+	return pw.podman != nil
+}
+
+// failedEvents returns an event stream that never sends any events, together
+// with an error stream that just reports the specified error.
+func failedEvents(err error) (<-chan engineclient.ContainerEvent, <-chan error) {
+	cntrerrstream := make(chan error, 1)
+	cntrerrstream <- err
+	close(cntrerrstream)
+	return make(chan engineclient.ContainerEvent), cntrerrstream
+}
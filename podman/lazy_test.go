@@ -0,0 +1,58 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"context"
+
+	"github.com/thediveo/sealwatcher/v2/util"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("lazy connections", func() {
+
+	It("defers connecting", func(ctx context.Context) {
+		pw := NewPodmanWatcher(nil, WithLazyConnection("unix:///bourish.socket.puppet"))
+		defer pw.Close()
+		Expect(pw.API()).To(Equal("unix:///bourish.socket.puppet"))
+		Expect(pw.Client()).To(BeNil())
+		Expect(pw.ID(ctx)).To(Equal("unix:///bourish.socket.puppet"))
+		Expect(pw.isLocal()).To(BeFalse())
+
+		Expect(pw.Try(ctx)).To(MatchError(util.ErrServiceUnavailable))
+		Expect(pw.List(ctx)).Error().To(HaveOccurred())
+		_, errs := pw.LifecycleEvents(ctx)
+		Expect(errs).To(Receive(HaveOccurred()))
+		Expect(errs).To(BeClosed())
+		Expect(pw.Client()).To(BeNil())
+	})
+
+	It("connects on demand", func(ctx context.Context) {
+		sockpath := fakePodmanService()
+		pw := NewPodmanWatcher(nil, WithLazyConnection("unix://"+sockpath))
+		defer pw.Close()
+		Expect(pw.Client()).To(BeNil())
+		Expect(pw.connected(ctx)).To(Succeed())
+		Expect(pw.Client()).NotTo(BeNil())
+		Expect(pw.API()).To(Equal("unix://" + sockpath))
+		Expect(pw.isLocal()).To(BeTrue())
+		conn := pw.conn()
+		Expect(pw.connected(ctx)).To(Succeed())
+		Expect(pw.conn()).To(BeIdenticalTo(conn))
+	})
+
+})
@@ -0,0 +1,166 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"runtime"
+	"strconv"
+
+	"github.com/containers/podman/v4/pkg/bindings"
+	"github.com/thediveo/sealwatcher/v2/util"
+	"golang.org/x/sys/unix"
+)
+
+// DefaultSocket is the URI of the rootful Podman API socket.
+const DefaultSocket = "unix:///run/podman/podman.sock"
+
+// NewConnectionForPID returns a new Podman connection context for the Podman
+// API socket located in the mount namespace of the process with the specified
+// PID. The socket is specified as a URI, defaulting to [DefaultSocket] when
+// empty. If pid is zero or the URI isn't a unix socket URI, then
+// NewConnectionForPID simply returns a Podman connection as usual, with the
+// usual client defaults applying.
+//
+// NewConnectionForPID first tries to reach the API socket via the process'
+// root directory in /proc/[PID]/root. If this fails, such as when the socket
+// path contains absolute symbolic links, then NewConnectionForPID falls back to
+// dialing the API socket from inside the mount namespace of the process, using
+// a dedicated and locked OS thread per dial.
+func NewConnectionForPID(ctx context.Context, pid int, podmansock string) (context.Context, error) {
+	conn, err := newConnectionForPID(ctx, "/proc", pid, podmansock)
+	if err != nil {
+		return nil, util.Classify(err)
+	}
+	return conn, nil
+}
+
+func newConnectionForPID(ctx context.Context, procroot string, pid int, podmansock string) (context.Context, error) {
+	if pid == 0 {
+		return bindings.NewConnection(ctx, podmansock)
+	}
+	sockpath, ok, err := unixSocketPath(podmansock)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return bindings.NewConnection(ctx, podmansock)
+	}
+	procsock := procroot + "/" + strconv.Itoa(pid) + "/root" + sockpath
+	conn, err := bindings.NewConnection(ctx, "unix://"+procsock)
+	if err == nil {
+		return conn, nil
+	}
+	// The Podman bindings don't allow us to pass in a custom dialer, but they
+	// insist on pinging the API service. So we first open the socket from
+	// inside the mount namespace as an O_PATH fd and then connect through the
+	// magic /proc/self/fd/[FD] link for the ping. Afterwards, we switch over to
+	// our own dialer that enters the mount namespace, so we don't get stuck
+	// with a stale socket inode after the API service has restarted.
+	var sockfd int
+	if nserr := inMountNamespace(procroot, pid, func() (err error) {
+		sockfd, err = unix.Open(sockpath, unix.O_PATH|unix.O_CLOEXEC, 0)
+		return
+	}); nserr != nil {
+		return nil, fmt.Errorf("cannot reach Podman API socket %s of process PID %d: %w",
+			sockpath, pid, nserr)
+	}
+	defer unix.Close(sockfd)
+	conn, err = bindings.NewConnection(ctx, "unix:///proc/self/fd/"+strconv.Itoa(sockfd))
+	if err != nil {
+		return nil, err
+	}
+	client, err := bindings.GetClient(conn)
+	if err != nil {
+		return nil, err
+	}
+	client.Client.CloseIdleConnections()
+	client.URI = &url.URL{Scheme: "unix", Path: procsock}
+	client.Client = &http.Client{
+		Transport: &http.Transport{
+			DialContext:        mountNamespaceDialer(procroot, pid, sockpath),
+			DisableCompression: true,
+		},
+	}
+	return conn, nil
+}
+
+// unixSocketPath returns the path of the unix socket specified by the URI,
+// defaulting to [DefaultSocket] if the URI is empty. It returns false if the
+// URI doesn't specify a unix socket.
+func unixSocketPath(podmansock string) (string, bool, error) {
+	if podmansock == "" {
+		podmansock = DefaultSocket
+	}
+	sockurl, err := url.Parse(podmansock)
+	if err != nil {
+		return "", false, err
+	}
+	if sockurl.Scheme != "unix" {
+		return "", false, nil
+	}
+	// Mirror the bindings' autofix of unix://path_element vs.
+	// unix:///path_element.
+	return path.Join("/", sockurl.Host, sockurl.Path), true, nil
+}
+
+// mountNamespaceDialer returns a dial function that connects to the specified
+// unix socket path inside the mount namespace of the process with the
+// specified PID.
+func mountNamespaceDialer(procroot string, pid int, sockpath string) func(context.Context, string, string) (net.Conn, error) {
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var conn net.Conn
+		err := inMountNamespace(procroot, pid, func() (err error) {
+			conn, err = (&net.Dialer{}).DialContext(ctx, "unix", sockpath)
+			return
+		})
+		return conn, err
+	}
+}
+
+// inMountNamespace runs the specified function on a separate and locked OS
+// thread that has been switched into the mount namespace of the process with
+// the specified PID, returning the function's error, if any. As the OS thread
+// cannot be switched back, it gets thrown away after the function returns.
+func inMountNamespace(procroot string, pid int, fn func() error) error {
+	mntnsfd, err := unix.Open(procroot+"/"+strconv.Itoa(pid)+"/ns/mnt", unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("cannot open mount namespace of process PID %d: %w", pid, err)
+	}
+	defer unix.Close(mntnsfd)
+	result := make(chan error, 1)
+	go func() {
+		// Never unlock the OS thread: when this goroutine ends, the Go runtime
+		// terminates the locked thread instead of reusing it.
+		runtime.LockOSThread()
+		// Switching mount namespaces requires the thread to not share its
+		// filesystem attributes with the other threads of our process.
+		if err := unix.Unshare(unix.CLONE_FS); err != nil {
+			result <- fmt.Errorf("cannot unshare filesystem attributes: %w", err)
+			return
+		}
+		if err := unix.Setns(mntnsfd, unix.CLONE_NEWNS); err != nil {
+			result <- fmt.Errorf("cannot enter mount namespace of process PID %d: %w", pid, err)
+			return
+		}
+		result <- fn()
+	}()
+	return <-result
+}
@@ -0,0 +1,103 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/containers/podman/v4/pkg/bindings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/thediveo/success"
+)
+
+// fakePodmanService serves just the ping endpoint of the Podman API on a new
+// unix socket, returning the socket's path.
+func fakePodmanService() string {
+	GinkgoHelper()
+	sockpath := filepath.Join(GinkgoT().TempDir(), "podman.sock")
+	serveFakePodman(sockpath)
+	return sockpath
+}
+
+// serveFakePodman serves just the ping endpoint of the Podman API on the unix
+// socket at the specified path.
+func serveFakePodman(sockpath string) {
+	GinkgoHelper()
+	l := Successful(net.Listen("unix", sockpath))
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Libpod-API-Version", "4.5.0")
+			w.WriteHeader(http.StatusOK)
+		}),
+	}
+	go func() { _ = srv.Serve(l) }()
+	DeferCleanup(func() { _ = srv.Close() })
+}
+
+// ping the Podman API service of the specified connection.
+func ping(conn context.Context) {
+	GinkgoHelper()
+	client := Successful(bindings.GetClient(conn))
+	resp := Successful(client.DoRequest(conn, nil, http.MethodGet, "/_ping", nil, nil))
+	resp.Body.Close()
+	Expect(resp.StatusCode).To(Equal(http.StatusOK))
+	client.Client.CloseIdleConnections()
+}
+
+var _ = Describe("connecting to Podman in other mount namespaces", func() {
+
+	It("reports errors", func(ctx context.Context) {
+		Expect(NewConnectionForPID(ctx, 0, "unix:///bourish.socket.puppet")).Error().To(HaveOccurred())
+		Expect(NewConnectionForPID(ctx, os.Getpid(), "unix:///bourish.socket.puppet")).Error().To(HaveOccurred())
+		Expect(NewConnectionForPID(ctx, os.Getpid(), "unix:///bourish\x7f")).Error().To(HaveOccurred())
+		procroot := GinkgoT().TempDir()
+		Expect(newConnectionForPID(ctx, procroot, 1, "unix:///bourish.socket.puppet")).Error().To(
+			MatchError(ContainSubstring("cannot open mount namespace")))
+	})
+
+	It("connects via the process' root directory", func(ctx context.Context) {
+		sockpath := fakePodmanService()
+		conn := Successful(NewConnectionForPID(ctx, os.Getpid(), "unix://"+sockpath))
+		client := Successful(bindings.GetClient(conn))
+		Expect(client.URI.Path).To(Equal("/proc/" + strconv.Itoa(os.Getpid()) + "/root" + sockpath))
+		ping(conn)
+	})
+
+	It("connects from inside the mount namespace", func(ctx context.Context) {
+		if os.Geteuid() != 0 {
+			Skip("needs root")
+		}
+		sockpath := fakePodmanService()
+		// a fake procfs without the process' root directory, but with its
+		// mount namespace.
+		procroot := GinkgoT().TempDir()
+		Expect(os.MkdirAll(filepath.Join(procroot, "42", "ns"), 0755)).To(Succeed())
+		Expect(os.Symlink("/proc/self/ns/mnt", filepath.Join(procroot, "42", "ns", "mnt"))).To(Succeed())
+
+		conn := Successful(newConnectionForPID(ctx, procroot, 42, "unix://"+sockpath))
+		client := Successful(bindings.GetClient(conn))
+		Expect(client.URI.String()).To(Equal("unix://" + procroot + "/42/root" + sockpath))
+		ping(conn)
+		ping(conn)
+	})
+
+})
@@ -0,0 +1,332 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/thediveo/sealwatcher/v2/util"
+	"github.com/thediveo/whalewatcher"
+	"github.com/thediveo/whalewatcher/watcher"
+)
+
+// DefaultNestedSocketPaths are the usual paths of Podman API sockets inside
+// containers that [NestedDiscovery] looks for by default. Paths may contain
+// glob patterns, such as for the sockets of rootless Podman services.
+var DefaultNestedSocketPaths = []string{
+	"/run/podman/podman.sock",
+	"/var/run/podman/podman.sock",
+	"/run/user/*/podman/podman.sock",
+}
+
+// DefaultNestedDiscoveryInterval is the default interval between checking the
+// alive containers for nested Podman API sockets.
+const DefaultNestedDiscoveryInterval = 5 * time.Second
+
+// NestedEngine describes a Podman engine discovered inside a container.
+type NestedEngine struct {
+	ContainerID       string          // ID of the container the nested engine runs in.
+	ParentContainerID string          // ID of the container's parent container, if nested itself.
+	PID               int             // PID of the container's initial process.
+	Socket            string          // API socket path, as seen inside the container.
+	Watcher           watcher.Watcher // watcher of the nested engine.
+}
+
+// NestedDiscovery discovers nested Podman engines running inside the alive
+// containers of a parent watcher, such as in Podman-in-Podman CI runners and
+// toolbox-style development containers. For each container with a Podman API
+// socket at one of the usual paths, NestedDiscovery starts a child watcher,
+// stopping it again when the container dies. The containers of nested
+// engines are in turn checked for further nested engines, building a
+// hierarchy of engines.
+//
+// Containers of nested engines are annotated with the ID of the container
+// their engine runs in, using the [ParentContainerLabelName] label. Their
+// PIDs are translated into the caller's PID namespace, see
+// [WithPIDTranslation].
+type NestedDiscovery struct {
+	procroot string
+	parent   watcher.Watcher
+	sockets  []string
+	interval time.Duration
+	backoff  func() backoff.BackOff
+	opts     []NewOption
+	// creates child watchers; replaceable for testing.
+	newWatcher func(pid int, socket string, opts ...NewOption) (watcher.Watcher, error)
+
+	mu      sync.Mutex
+	engines map[string]*nestedEngine // by ID of container the engine runs in.
+	wg      sync.WaitGroup
+}
+
+// nestedEngine is a nested engine together with the watcher of the engine
+// the engine's container belongs to, and how to stop the nested engine's
+// watcher.
+type nestedEngine struct {
+	NestedEngine
+	parent watcher.Watcher
+	cancel context.CancelFunc
+}
+
+// NestedOption represents options to [NewNestedDiscovery].
+type NestedOption func(*NestedDiscovery)
+
+// WithNestedSocketPaths sets the Podman API socket paths to look for inside
+// containers, instead of [DefaultNestedSocketPaths].
+func WithNestedSocketPaths(paths ...string) NestedOption {
+	return func(d *NestedDiscovery) {
+		d.sockets = paths
+	}
+}
+
+// WithNestedDiscoveryInterval sets the interval between checking the alive
+// containers for nested Podman API sockets, instead of
+// [DefaultNestedDiscoveryInterval].
+func WithNestedDiscoveryInterval(interval time.Duration) NestedOption {
+	return func(d *NestedDiscovery) {
+		if interval > 0 {
+			d.interval = interval
+		}
+	}
+}
+
+// WithNestedBackOff sets the function for creating a backoff for each child
+// watcher. Without it, child watchers don't retry failed operations and are
+// instead restarted upon the next discovery.
+func WithNestedBackOff(buggeroff func() backoff.BackOff) NestedOption {
+	return func(d *NestedDiscovery) {
+		d.backoff = buggeroff
+	}
+}
+
+// WithNestedOptions sets the options for creating the child Podman watchers.
+func WithNestedOptions(opts ...NewOption) NestedOption {
+	return func(d *NestedDiscovery) {
+		d.opts = opts
+	}
+}
+
+// WithParentContainer annotates all containers with the specified parent
+// container ID, using the [ParentContainerLabelName] label. This option is
+// used by [NestedDiscovery] for the watchers of nested engines.
+func WithParentContainer(id string) NewOption {
+	return func(pw *PodmanWatcher) {
+		pw.parent = id
+	}
+}
+
+// NewNestedDiscovery returns a new discovery of nested Podman engines inside
+// the containers of the specified parent watcher. Use [NestedDiscovery.Run]
+// to start the discovery.
+func NewNestedDiscovery(parent watcher.Watcher, opts ...NestedOption) *NestedDiscovery {
+	d := &NestedDiscovery{
+		procroot: "/proc",
+		parent:   parent,
+		sockets:  DefaultNestedSocketPaths,
+		interval: DefaultNestedDiscoveryInterval,
+		engines:  map[string]*nestedEngine{},
+	}
+	d.newWatcher = d.newPodmanWatcher
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// Run the discovery of nested engines until the specified context gets
+// cancelled, then stop all child watchers and return the context's error.
+func (d *NestedDiscovery) Run(ctx context.Context) error {
+	t := time.NewTicker(d.interval)
+	defer t.Stop()
+	for {
+		d.discover(ctx)
+		select {
+		case <-ctx.Done():
+			d.mu.Lock()
+			for id, e := range d.engines {
+				e.cancel()
+				delete(d.engines, id)
+			}
+			d.mu.Unlock()
+			d.wg.Wait()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Engines returns the currently discovered nested engines, sorted by the IDs
+// of the containers they run in.
+func (d *NestedDiscovery) Engines() []NestedEngine {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	engines := make([]NestedEngine, 0, len(d.engines))
+	for _, e := range d.engines {
+		engines = append(engines, e.NestedEngine)
+	}
+	sort.Slice(engines, func(i, j int) bool {
+		return engines[i].ContainerID < engines[j].ContainerID
+	})
+	return engines
+}
+
+// discover checks the alive containers of the parent watcher as well as of all
+// child watchers for Podman API sockets, starting child watchers for newly
+// found nested engines and stopping the child watchers of engines whose
+// containers have gone.
+func (d *NestedDiscovery) discover(ctx context.Context) {
+	d.mu.Lock()
+	watchers := []watcher.Watcher{d.parent}
+	for _, e := range d.engines {
+		watchers = append(watchers, e.Watcher)
+	}
+	d.mu.Unlock()
+
+	alive := map[string]bool{}
+	for _, w := range watchers {
+		for _, cntr := range portfolioContainers(w.Portfolio()) {
+			if cntr.PID == 0 {
+				continue
+			}
+			if _, invisible := cntr.Labels[PIDInvisibleLabelName]; invisible {
+				continue
+			}
+			alive[cntr.ID] = true
+			d.mu.Lock()
+			_, known := d.engines[cntr.ID]
+			d.mu.Unlock()
+			if known {
+				continue
+			}
+			d.start(ctx, w, cntr)
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// Stop the child watchers of engines whose containers have gone, as well
+	// as the child watchers of engines nested inside them.
+	stopped := map[watcher.Watcher]bool{}
+	for pruned := true; pruned; {
+		pruned = false
+		for id, e := range d.engines {
+			if alive[id] && !stopped[e.parent] {
+				continue
+			}
+			e.cancel()
+			delete(d.engines, id)
+			stopped[e.Watcher] = true
+			pruned = true
+		}
+	}
+}
+
+// start a child watcher for a nested engine inside the specified container,
+// if the container has a Podman API socket.
+func (d *NestedDiscovery) start(ctx context.Context, parent watcher.Watcher, cntr *whalewatcher.Container) {
+	socket := d.findSocket(cntr.PID)
+	if socket == "" {
+		return
+	}
+	opts := append([]NewOption{WithPID(cntr.PID), WithPIDTranslation()}, d.opts...)
+	opts = append(opts, WithParentContainer(cntr.ID))
+	w, err := d.newWatcher(cntr.PID, socket, opts...)
+	if err != nil {
+		return
+	}
+	wctx, cancel := context.WithCancel(ctx)
+	e := &nestedEngine{
+		NestedEngine: NestedEngine{
+			ContainerID:       cntr.ID,
+			ParentContainerID: cntr.Labels[ParentContainerLabelName],
+			PID:               cntr.PID,
+			Socket:            socket,
+			Watcher:           w,
+		},
+		parent: parent,
+		cancel: cancel,
+	}
+	d.mu.Lock()
+	d.engines[cntr.ID] = e
+	d.mu.Unlock()
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		defer cancel()
+		_ = w.Watch(wctx)
+		// When the watcher gave up, forget about the nested engine so it gets
+		// rediscovered later, unless the engine has already been replaced.
+		d.mu.Lock()
+		if d.engines[e.ContainerID] == e {
+			delete(d.engines, e.ContainerID)
+		}
+		d.mu.Unlock()
+		w.Close()
+	}()
+}
+
+// findSocket returns the path of the first Podman API socket found inside the
+// mount namespace of the process with the specified PID, or "" if none could
+// be found.
+func (d *NestedDiscovery) findSocket(pid int) string {
+	root := d.procroot + "/" + strconv.Itoa(pid) + "/root"
+	for _, pattern := range d.sockets {
+		matches, _ := filepath.Glob(root + pattern)
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil || info.Mode()&os.ModeSocket == 0 {
+				continue
+			}
+			return strings.TrimPrefix(match, root)
+		}
+	}
+	return ""
+}
+
+// newPodmanWatcher returns a new watcher for the Podman API socket inside the
+// mount namespace of the process with the specified PID.
+func (d *NestedDiscovery) newPodmanWatcher(pid int, socket string, opts ...NewOption) (watcher.Watcher, error) {
+	conn, err := newConnectionForPID(context.Background(), d.procroot, pid, "unix://"+socket)
+	if err != nil {
+		return nil, util.Classify(err)
+	}
+	var buggeroff backoff.BackOff
+	if d.backoff != nil {
+		buggeroff = d.backoff()
+	}
+	return watcher.New(NewPodmanWatcher(conn, opts...), buggeroff), nil
+}
+
+// portfolioContainers returns all containers in the specified portfolio.
+func portfolioContainers(portfolio *whalewatcher.Portfolio) []*whalewatcher.Container {
+	if portfolio == nil {
+		return nil
+	}
+	cntrs := []*whalewatcher.Container{}
+	for _, name := range portfolio.Names() {
+		if project := portfolio.Project(name); project != nil {
+			cntrs = append(cntrs, project.Containers()...)
+		}
+	}
+	return cntrs
+}
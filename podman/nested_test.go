@@ -0,0 +1,175 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/thediveo/whalewatcher"
+	"github.com/thediveo/whalewatcher/watcher"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/thediveo/success"
+)
+
+// childWatcher is a fake watcher that watches until its context gets
+// cancelled.
+type childWatcher struct {
+	*fakeWatcher
+	opts   []NewOption
+	closed atomic.Bool
+}
+
+func (w *childWatcher) Watch(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (w *childWatcher) Close() { w.closed.Store(true) }
+
+// fakeSocket creates a unix socket at the specified path inside the root
+// directory of the process with the specified PID in the fake procfs.
+func fakeSocket(procroot string, pid int, path string) {
+	GinkgoHelper()
+	sockpath := filepath.Join(procroot, strconv.Itoa(pid), "root", path)
+	Expect(os.MkdirAll(filepath.Dir(sockpath), 0755)).To(Succeed())
+	l := Successful(net.Listen("unix", sockpath))
+	DeferCleanup(func() { _ = l.Close() })
+}
+
+var _ = Describe("nested engine discovery", func() {
+
+	idA := strings.Repeat("a", 64)
+	idB := strings.Repeat("b", 64)
+	idC := strings.Repeat("c", 64)
+
+	var procroot string
+
+	BeforeEach(func() {
+		procroot = GinkgoT().TempDir()
+	})
+
+	It("finds Podman API sockets", func() {
+		fakeSocket(procroot, 100, "/run/user/1000/podman/podman.sock")
+		notasock := filepath.Join(procroot, "100", "root", "run", "podman", "podman.sock")
+		Expect(os.MkdirAll(filepath.Dir(notasock), 0755)).To(Succeed())
+		Expect(os.WriteFile(notasock, nil, 0644)).To(Succeed())
+
+		d := NewNestedDiscovery(nil)
+		d.procroot = procroot
+		Expect(d.findSocket(100)).To(Equal("/run/user/1000/podman/podman.sock"))
+		Expect(d.findSocket(666)).To(BeEmpty())
+
+		WithNestedSocketPaths("/run/podman/podman.sock")(d)
+		Expect(d.findSocket(100)).To(BeEmpty())
+	})
+
+	It("discovers and stops nested engines", func(ctx context.Context) {
+		fakeSocket(procroot, 100, "/run/podman/podman.sock")
+		fakeSocket(procroot, 300, "/run/podman/podman.sock")
+
+		parent := newFakeWatcher("parent",
+			&whalewatcher.Container{ID: idA, Name: "a", PID: 100, Labels: map[string]string{}},
+			&whalewatcher.Container{ID: idB, Name: "b", PID: 200, Labels: map[string]string{}})
+		children := map[int]*childWatcher{}
+		d := NewNestedDiscovery(parent,
+			WithNestedDiscoveryInterval(0),
+			WithNestedOptions(WithImageAnnotations()))
+		Expect(d.interval).To(Equal(DefaultNestedDiscoveryInterval))
+		d.procroot = procroot
+		d.newWatcher = func(pid int, socket string, opts ...NewOption) (watcher.Watcher, error) {
+			Expect(socket).To(Equal("/run/podman/podman.sock"))
+			w := &childWatcher{fakeWatcher: newFakeWatcher(strconv.Itoa(pid)), opts: opts}
+			if pid == 100 {
+				w.portfolio.Add(&whalewatcher.Container{ID: idC, Name: "c", PID: 300,
+					Labels: map[string]string{ParentContainerLabelName: idA}})
+			}
+			children[pid] = w
+			return w, nil
+		}
+
+		By("discovering a nested engine")
+		d.discover(ctx)
+		Expect(d.Engines()).To(HaveExactElements(
+			And(HaveField("ContainerID", idA), HaveField("ParentContainerID", ""),
+				HaveField("PID", 100), HaveField("Socket", "/run/podman/podman.sock"))))
+		pw := &PodmanWatcher{}
+		for _, opt := range children[100].opts {
+			opt(pw)
+		}
+		Expect(pw.pid).To(Equal(100))
+		Expect(pw.pidxlate).NotTo(BeEmpty())
+		Expect(pw.images).To(BeTrue())
+		Expect(pw.parent).To(Equal(idA))
+
+		By("discovering a nested engine inside a nested engine")
+		d.discover(ctx)
+		Expect(d.Engines()).To(HaveExactElements(
+			HaveField("ContainerID", idA),
+			And(HaveField("ContainerID", idC), HaveField("ParentContainerID", idA))))
+
+		By("stopping the nested engines when their container is gone")
+		parent.portfolio = whalewatcher.NewPortfolio()
+		parent.portfolio.Add(&whalewatcher.Container{ID: idB, Name: "b", PID: 200, Labels: map[string]string{}})
+		d.discover(ctx)
+		Expect(d.Engines()).To(BeEmpty())
+		Eventually(children[100].closed.Load).Should(BeTrue())
+		Eventually(children[300].closed.Load).Should(BeTrue())
+		d.wg.Wait()
+	})
+
+	It("ignores nested engines it cannot connect to", func(ctx context.Context) {
+		fakeSocket(procroot, 100, "/run/podman/podman.sock")
+		parent := newFakeWatcher("parent",
+			&whalewatcher.Container{ID: idA, Name: "a", PID: 100, Labels: map[string]string{}})
+		d := NewNestedDiscovery(parent)
+		d.procroot = procroot
+		d.newWatcher = func(int, string, ...NewOption) (watcher.Watcher, error) {
+			return nil, errors.New("D'OH!")
+		}
+		d.discover(ctx)
+		Expect(d.Engines()).To(BeEmpty())
+	})
+
+	It("stops all nested engines when done", func(ctx context.Context) {
+		fakeSocket(procroot, 100, "/run/podman/podman.sock")
+		parent := newFakeWatcher("parent",
+			&whalewatcher.Container{ID: idA, Name: "a", PID: 100, Labels: map[string]string{}})
+		var child *childWatcher
+		d := NewNestedDiscovery(parent)
+		d.procroot = procroot
+		d.newWatcher = func(pid int, socket string, opts ...NewOption) (watcher.Watcher, error) {
+			child = &childWatcher{fakeWatcher: newFakeWatcher(strconv.Itoa(pid))}
+			return child, nil
+		}
+		ctx, cancel := context.WithCancel(ctx)
+		done := make(chan error)
+		go func() { done <- d.Run(ctx) }()
+		Eventually(d.Engines).Should(HaveLen(1))
+		cancel()
+		Eventually(done).Should(Receive(MatchError(context.Canceled)))
+		Expect(d.Engines()).To(BeEmpty())
+		Expect(child.closed.Load()).To(BeTrue())
+	})
+
+})
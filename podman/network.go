@@ -0,0 +1,211 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/containers/podman/v4/libpod/define"
+	"github.com/containers/podman/v4/pkg/bindings/containers"
+	"github.com/jellydator/ttlcache/v3"
+	"github.com/thediveo/whalewatcher"
+)
+
+// NetworksRucksackKey is the [MultiRucksack] key for a container's
+// [NetworkInfo].
+const NetworksRucksackKey = PodmanAnnotation + "networks"
+
+// NetworkInfo describes the networks a container is attached to, as well as
+// its published ports.
+type NetworkInfo struct {
+	// ID of the container whose network namespace this container shares, such
+	// as the infra container of the pod this container belongs to. Empty if
+	// the container has its own network namespace.
+	SharedFrom string
+	// Networks the container is attached to, indexed by network name.
+	Networks map[string]NetworkAttachment
+	// Ports exposed by the container, sorted by container port and protocol.
+	Ports []PortMapping
+}
+
+// NetworkAttachment describes a container's attachment to a particular
+// network.
+type NetworkAttachment struct {
+	IPAddresses []string // IPv4 and IPv6 addresses in CIDR notation.
+	Gateways    []string // IPv4 and/or IPv6 gateway addresses.
+	MACAddress  string   // MAC address, if any.
+	Aliases     []string // network aliases, if any.
+}
+
+// PortMapping describes an exposed container port and where it has been
+// published on the host, if at all.
+type PortMapping struct {
+	ContainerPort uint16 // exposed port number inside the container.
+	Protocol      string // "tcp", "udp", or "sctp".
+	HostIP        string // host IP address bound to; empty if all addresses.
+	HostPort      uint16 // published host port number; 0 if not published.
+}
+
+// ContainerNetworks returns the network information of the specified
+// container, or nil if there is none, such as when [WithNetworkInfo] wasn't
+// specified. Containers sharing the same network namespace might share the
+// same network information, so it must not be modified.
+func ContainerNetworks(cntr *whalewatcher.Container) *NetworkInfo {
+	netinfo, _ := UnpackRucksack[*NetworkInfo](cntr, NetworksRucksackKey)
+	return netinfo
+}
+
+// packNetworkInfo packs the network information of the specified container
+// into its Rucksack. Containers sharing the network namespace of another
+// container, such as pod members sharing the network namespace of their pod's
+// infra container, get the network information of that other container.
+//
+// A plain, non-[MultiRucksack] Rucksack packed by an application's unkeyed
+// Rucksack packer is left untouched, so that its type doesn't change behind
+// the application's back; the container then lacks network information.
+func (pw *PodmanWatcher) packNetworkInfo(ctx context.Context, cntr *whalewatcher.Container, details *define.InspectContainerData) {
+	if _, ok := cntr.Rucksack.(MultiRucksack); !ok && cntr.Rucksack != nil {
+		return
+	}
+	if details.HostConfig != nil && strings.HasPrefix(details.HostConfig.NetworkMode, "container:") {
+		netinfo := pw.sharedNetworkInfo(ctx, strings.TrimPrefix(details.HostConfig.NetworkMode, "container:"))
+		if netinfo == nil {
+			return
+		}
+		PackRucksack(cntr, NetworksRucksackKey, netinfo)
+		return
+	}
+	PackRucksack(cntr, NetworksRucksackKey, newNetworkInfo(details.NetworkSettings))
+}
+
+// sharedNetworkInfo returns the network information of the specified container
+// whose network namespace is shared by other containers, or nil if the
+// container couldn't be inspected. As usually all members of a pod share the
+// network namespace of the same infra container, the network information gets
+// cached, until the network-sharing container dies.
+func (pw *PodmanWatcher) sharedNetworkInfo(ctx context.Context, sharedfrom string) *NetworkInfo {
+	if netinfo := pw.netcache.Get(sharedfrom); netinfo != nil {
+		return netinfo.Value()
+	}
+	sharerdetails, err := containers.Inspect(ctx, sharedfrom, nil)
+	if err != nil {
+		// We don't do negative caching here.
+		return nil
+	}
+	netinfo := newNetworkInfo(sharerdetails.NetworkSettings)
+	netinfo.SharedFrom = sharedfrom
+	pw.netcache.Set(sharedfrom, netinfo, ttlcache.DefaultTTL)
+	return netinfo
+}
+
+// forgetSharedNetworkInfo removes any cached network information of the
+// specified container, such as when it has died.
+func (pw *PodmanWatcher) forgetSharedNetworkInfo(id string) {
+	if pw.netcache != nil {
+		pw.netcache.Delete(id)
+	}
+}
+
+// newNetworkInfo returns the network information derived from the specified
+// container network settings.
+func newNetworkInfo(settings *define.InspectNetworkSettings) *NetworkInfo {
+	netinfo := &NetworkInfo{
+		Networks: map[string]NetworkAttachment{},
+		Ports:    []PortMapping{},
+	}
+	if settings == nil {
+		return netinfo
+	}
+	for name, network := range settings.Networks {
+		if network == nil {
+			continue
+		}
+		netinfo.Networks[name] = NetworkAttachment{
+			IPAddresses: ipAddresses(&network.InspectBasicNetworkConfig),
+			Gateways:    gateways(&network.InspectBasicNetworkConfig),
+			MACAddress:  network.MacAddress,
+			Aliases:     network.Aliases,
+		}
+	}
+	for port, bindings := range settings.Ports {
+		portnum, proto, _ := strings.Cut(port, "/")
+		if proto == "" {
+			proto = "tcp"
+		}
+		containerport, err := strconv.ParseUint(portnum, 10, 16)
+		if err != nil {
+			continue
+		}
+		if len(bindings) == 0 {
+			netinfo.Ports = append(netinfo.Ports, PortMapping{
+				ContainerPort: uint16(containerport),
+				Protocol:      proto,
+			})
+			continue
+		}
+		for _, binding := range bindings {
+			hostport, _ := strconv.ParseUint(binding.HostPort, 10, 16)
+			netinfo.Ports = append(netinfo.Ports, PortMapping{
+				ContainerPort: uint16(containerport),
+				Protocol:      proto,
+				HostIP:        binding.HostIP,
+				HostPort:      uint16(hostport),
+			})
+		}
+	}
+	sort.SliceStable(netinfo.Ports, func(i, j int) bool {
+		if netinfo.Ports[i].ContainerPort != netinfo.Ports[j].ContainerPort {
+			return netinfo.Ports[i].ContainerPort < netinfo.Ports[j].ContainerPort
+		}
+		return netinfo.Ports[i].Protocol < netinfo.Ports[j].Protocol
+	})
+	return netinfo
+}
+
+// ipAddresses returns the IPv4 and IPv6 addresses in CIDR notation from the
+// specified basic network configuration.
+func ipAddresses(config *define.InspectBasicNetworkConfig) []string {
+	addrs := []string{}
+	if config.IPAddress != "" {
+		addrs = append(addrs, fmt.Sprintf("%s/%d", config.IPAddress, config.IPPrefixLen))
+	}
+	for _, addr := range config.SecondaryIPAddresses {
+		addrs = append(addrs, fmt.Sprintf("%s/%d", addr.Addr, addr.PrefixLength))
+	}
+	if config.GlobalIPv6Address != "" {
+		addrs = append(addrs, fmt.Sprintf("%s/%d", config.GlobalIPv6Address, config.GlobalIPv6PrefixLen))
+	}
+	for _, addr := range config.SecondaryIPv6Addresses {
+		addrs = append(addrs, fmt.Sprintf("%s/%d", addr.Addr, addr.PrefixLength))
+	}
+	return addrs
+}
+
+// gateways returns the IPv4 and IPv6 gateway addresses from the specified basic
+// network configuration.
+func gateways(config *define.InspectBasicNetworkConfig) []string {
+	gws := []string{}
+	if config.Gateway != "" {
+		gws = append(gws, config.Gateway)
+	}
+	if config.IPv6Gateway != "" {
+		gws = append(gws, config.IPv6Gateway)
+	}
+	return gws
+}
@@ -0,0 +1,116 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"context"
+
+	"github.com/containers/podman/v4/libpod/define"
+	"github.com/jellydator/ttlcache/v3"
+	"github.com/thediveo/whalewatcher"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("network information", func() {
+
+	It("returns nil for containers without network information", func() {
+		Expect(ContainerNetworks(&whalewatcher.Container{})).To(BeNil())
+	})
+
+	It("handles missing network settings", func() {
+		Expect(newNetworkInfo(nil)).To(Equal(&NetworkInfo{
+			Networks: map[string]NetworkAttachment{},
+			Ports:    []PortMapping{},
+		}))
+	})
+
+	It("derives network attachments and ports", func() {
+		netinfo := newNetworkInfo(&define.InspectNetworkSettings{
+			Ports: map[string][]define.InspectHostPort{
+				"443/tcp": {{HostPort: "8443"}},
+				"53/udp":  {{HostIP: "127.0.0.1", HostPort: "5353"}},
+				"53/tcp":  nil,
+				"foo/tcp": nil,
+			},
+			Networks: map[string]*define.InspectAdditionalNetwork{
+				"podman": {
+					InspectBasicNetworkConfig: define.InspectBasicNetworkConfig{
+						Gateway:     "10.88.0.1",
+						IPAddress:   "10.88.0.42",
+						IPPrefixLen: 16,
+						SecondaryIPAddresses: []define.Address{
+							{Addr: "10.88.0.43", PrefixLength: 16},
+						},
+						IPv6Gateway:         "fd00::1",
+						GlobalIPv6Address:   "fd00::42",
+						GlobalIPv6PrefixLen: 64,
+						MacAddress:          "de:ad:be:ef:00:01",
+					},
+					Aliases: []string{"furuncle"},
+				},
+				"broken": nil,
+			},
+		})
+		Expect(netinfo.Networks).To(HaveLen(1))
+		Expect(netinfo.Networks).To(HaveKeyWithValue("podman", NetworkAttachment{
+			IPAddresses: []string{"10.88.0.42/16", "10.88.0.43/16", "fd00::42/64"},
+			Gateways:    []string{"10.88.0.1", "fd00::1"},
+			MACAddress:  "de:ad:be:ef:00:01",
+			Aliases:     []string{"furuncle"},
+		}))
+		Expect(netinfo.Ports).To(Equal([]PortMapping{
+			{ContainerPort: 53, Protocol: "tcp"},
+			{ContainerPort: 53, Protocol: "udp", HostIP: "127.0.0.1", HostPort: 5353},
+			{ContainerPort: 443, Protocol: "tcp", HostPort: 8443},
+		}))
+	})
+
+	It("caches the network information of network-sharing containers", func(ctx context.Context) {
+		pw := NewPodmanWatcher(nil, WithNetworkInfo())
+		defer pw.Close()
+		details := &define.InspectContainerData{
+			HostConfig: &define.InspectContainerHostConfig{NetworkMode: "container:abc"},
+		}
+		pw.netcache.Set("abc", &NetworkInfo{SharedFrom: "abc"}, ttlcache.DefaultTTL)
+
+		cntr := &whalewatcher.Container{}
+		pw.packNetworkInfo(ctx, cntr, details)
+		Expect(ContainerNetworks(cntr)).To(HaveField("SharedFrom", "abc"))
+
+		pw.forgetSharedNetworkInfo("abc")
+		cntr = &whalewatcher.Container{}
+		pw.packNetworkInfo(ctx, cntr, details)
+		Expect(ContainerNetworks(cntr)).To(BeNil())
+	})
+
+	It("doesn't change the type of plain Rucksacks", func(ctx context.Context) {
+		pw := NewPodmanWatcher(nil, WithNetworkInfo())
+		defer pw.Close()
+		details := &define.InspectContainerData{}
+
+		cntr := &whalewatcher.Container{Rucksack: "foobar"}
+		pw.packNetworkInfo(ctx, cntr, details)
+		Expect(cntr.Rucksack).To(Equal("foobar"))
+		Expect(ContainerNetworks(cntr)).To(BeNil())
+
+		cntr = &whalewatcher.Container{Rucksack: MultiRucksack{"foo": "bar"}}
+		pw.packNetworkInfo(ctx, cntr, details)
+		Expect(cntr.Rucksack).To(HaveKeyWithValue("foo", "bar"))
+		Expect(ContainerNetworks(cntr)).NotTo(BeNil())
+	})
+
+})
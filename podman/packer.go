@@ -0,0 +1,162 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"github.com/thediveo/whalewatcher"
+	"github.com/thediveo/whalewatcher/engineclient"
+)
+
+// CompositePacker is a [engineclient.RucksackPacker] that runs multiple
+// Rucksack packers in the order of their registration, storing what each
+// packer packed into a [MultiRucksack] under the key the packer was registered
+// with. Packers that already pack a MultiRucksack themselves, such as the
+// [DetailsPacker], get their items merged instead. Packers registered with
+// the same key are chained: each such packer gets passed the Rucksack as left
+// by the previous packer with the same key.
+//
+// A panicking packer doesn't take down the container inspection; instead, the
+// panic is recovered and the packer simply doesn't contribute to the
+// Rucksack. Use [CompositePacker.OnPanic] to get notified about such panics.
+type CompositePacker struct {
+	packers []keyedPacker
+	onpanic func(PackerPanic)
+}
+
+// keyedPacker is a Rucksack packer together with the key under which its
+// Rucksack contents get stored in a MultiRucksack.
+type keyedPacker struct {
+	key    string
+	packer engineclient.RucksackPacker
+}
+
+// PackerPanic describes a panic of a Rucksack packer that was recovered from.
+type PackerPanic struct {
+	Key         string      // key the packer was registered with.
+	ContainerID string      // ID of the container being packed.
+	Value       interface{} // value the packer panicked with.
+}
+
+var _ (engineclient.RucksackPacker) = (*CompositePacker)(nil)
+
+// NewCompositePacker returns a new and empty composite Rucksack packer.
+func NewCompositePacker() *CompositePacker {
+	return &CompositePacker{}
+}
+
+// Register the specified Rucksack packer, storing what it packs under the
+// specified key. Register returns the composite packer in order to allow
+// chaining registrations.
+func (c *CompositePacker) Register(key string, packer engineclient.RucksackPacker) *CompositePacker {
+	c.packers = append(c.packers, keyedPacker{key: key, packer: packer})
+	return c
+}
+
+// OnPanic sets the function to call when a packer panics. OnPanic returns the
+// composite packer in order to allow chaining.
+func (c *CompositePacker) OnPanic(fn func(PackerPanic)) *CompositePacker {
+	c.onpanic = fn
+	return c
+}
+
+// Pack runs all registered Rucksack packers in order of their registration,
+// collecting their Rucksack contents in the container's [MultiRucksack].
+func (c *CompositePacker) Pack(container *whalewatcher.Container, inspection interface{}) {
+	for _, kp := range c.packers {
+		rucksack := container.Rucksack
+		// Chain packers with the same key by handing them what the previous
+		// packer with the same key packed.
+		container.Rucksack, _ = UnpackRucksack[interface{}](container, kp.key)
+		ok := packSafely(kp, container, inspection, c.onpanic)
+		item := container.Rucksack
+		container.Rucksack = rucksack
+		if !ok {
+			continue
+		}
+		switch item := item.(type) {
+		case nil:
+		case MultiRucksack:
+			for key, value := range item {
+				PackRucksack(container, key, value)
+			}
+		default:
+			PackRucksack(container, kp.key, item)
+		}
+	}
+}
+
+// chainedPacker is a Rucksack packer that runs multiple packers in order on
+// the same plain Rucksack, so each packer gets passed the Rucksack as left by
+// the previous packer. A panicking packer leaves the Rucksack unchanged.
+type chainedPacker struct {
+	packers []engineclient.RucksackPacker
+	onpanic func(PackerPanic)
+}
+
+var _ (engineclient.RucksackPacker) = (*chainedPacker)(nil)
+
+// Pack runs all packers in order on the container's Rucksack.
+func (c *chainedPacker) Pack(container *whalewatcher.Container, inspection interface{}) {
+	for _, packer := range c.packers {
+		rucksack := container.Rucksack
+		if !packSafely(keyedPacker{packer: packer}, container, inspection, c.onpanic) {
+			container.Rucksack = rucksack
+		}
+	}
+}
+
+// newPacker returns the Rucksack packer for the specified packers, or nil if
+// there are no packers at all. A single unkeyed packer is used as is, while
+// multiple unkeyed packers get chained on the same plain Rucksack. Otherwise,
+// the packers get combined into a [CompositePacker].
+func newPacker(packers []keyedPacker, onpanic func(PackerPanic)) engineclient.RucksackPacker {
+	if len(packers) == 0 {
+		return nil
+	}
+	unkeyed := make([]engineclient.RucksackPacker, 0, len(packers))
+	for _, kp := range packers {
+		if kp.key != "" {
+			return &CompositePacker{packers: packers, onpanic: onpanic}
+		}
+		unkeyed = append(unkeyed, kp.packer)
+	}
+	if len(unkeyed) == 1 {
+		return unkeyed[0]
+	}
+	return &chainedPacker{packers: unkeyed, onpanic: onpanic}
+}
+
+// packSafely runs the specified Rucksack packer, recovering from any panic and
+// reporting it to the specified function, if any. In case of a panic,
+// packSafely returns false and the container's Rucksack is reset to nil, as
+// the packer might have left it in an inconsistent state.
+func packSafely(
+	kp keyedPacker,
+	container *whalewatcher.Container,
+	inspection interface{},
+	onpanic func(PackerPanic),
+) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			container.Rucksack = nil
+			ok = false
+			if onpanic != nil {
+				onpanic(PackerPanic{Key: kp.key, ContainerID: container.ID, Value: r})
+			}
+		}
+	}()
+	kp.packer.Pack(container, inspection)
+	return true
+}
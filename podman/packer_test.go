@@ -0,0 +1,149 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"context"
+
+	"github.com/containers/podman/v4/libpod/define"
+	"github.com/thediveo/whalewatcher"
+	"github.com/thediveo/whalewatcher/engineclient"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type valuePacker struct{ value interface{} }
+
+func (p valuePacker) Pack(container *whalewatcher.Container, inspection interface{}) {
+	container.Rucksack = p.value
+}
+
+// appendingPacker appends its value to the previous Rucksack contents.
+type appendingPacker struct{ value interface{} }
+
+func (p appendingPacker) Pack(container *whalewatcher.Container, inspection interface{}) {
+	container.Rucksack = []interface{}{container.Rucksack, p.value}
+}
+
+type panickyPacker struct{}
+
+func (p panickyPacker) Pack(container *whalewatcher.Container, inspection interface{}) {
+	container.Rucksack = "half-packed"
+	panic("dropped the rucksack")
+}
+
+var _ = Describe("composite rucksack packers", func() {
+
+	It("runs packers in order and stores their items under their keys", func() {
+		cp := NewCompositePacker().
+			Register("foo", valuePacker{value: 42}).
+			Register("bar", valuePacker{value: "baz"}).
+			Register("nil", valuePacker{}).
+			Register("foo", valuePacker{value: 666})
+		cntr := &whalewatcher.Container{}
+		cp.Pack(cntr, nil)
+		Expect(cntr.Rucksack).To(Equal(MultiRucksack{"foo": 666, "bar": "baz"}))
+	})
+
+	It("merges multi rucksacks", func() {
+		cp := NewCompositePacker().
+			Register("foo", valuePacker{value: 42}).
+			Register("details", DetailsPacker{})
+		cntr := &whalewatcher.Container{}
+		cp.Pack(cntr, &define.InspectContainerData{ID: "1234"})
+		Expect(cntr.Rucksack).To(HaveKeyWithValue("foo", 42))
+		Expect(ContainerDetails(cntr)).To(HaveField("ID", "1234"))
+	})
+
+	It("isolates panicking packers", func() {
+		cp := NewCompositePacker().
+			Register("foo", valuePacker{value: 42}).
+			Register("panic", panickyPacker{}).
+			Register("bar", valuePacker{value: "baz"})
+		cntr := &whalewatcher.Container{}
+		Expect(func() { cp.Pack(cntr, nil) }).NotTo(Panic())
+		Expect(cntr.Rucksack).To(Equal(MultiRucksack{"foo": 42, "bar": "baz"}))
+
+		cntr = &whalewatcher.Container{}
+		Expect(func() { packSafely(keyedPacker{packer: panickyPacker{}}, cntr, nil, nil) }).NotTo(Panic())
+		Expect(cntr.Rucksack).To(BeNil())
+	})
+
+	It("reports panicking packers", func() {
+		var panics []PackerPanic
+		cp := NewCompositePacker().
+			Register("panic", panickyPacker{}).
+			OnPanic(func(p PackerPanic) { panics = append(panics, p) })
+		cp.Pack(&whalewatcher.Container{ID: "1234"}, nil)
+		Expect(panics).To(ConsistOf(PackerPanic{Key: "panic", ContainerID: "1234", Value: "dropped the rucksack"}))
+
+		panics = nil
+		pw := NewPodmanWatcher(context.Background(),
+			WithRucksackPacker(panickyPacker{}),
+			WithPackerPanicHandler(func(p PackerPanic) { panics = append(panics, p) }))
+		defer pw.Close()
+		cntr := &whalewatcher.Container{ID: "5678"}
+		packSafely(keyedPacker{packer: pw.packer}, cntr, nil, pw.onpackerpanic)
+		Expect(panics).To(ConsistOf(HaveField("ContainerID", "5678")))
+	})
+
+	It("chains unkeyed packers", func() {
+		cntr := &whalewatcher.Container{}
+		(&chainedPacker{packers: []engineclient.RucksackPacker{
+			valuePacker{value: 42},
+			appendingPacker{value: 666},
+			panickyPacker{},
+		}}).Pack(cntr, nil)
+		Expect(cntr.Rucksack).To(Equal([]interface{}{42, 666}))
+
+		cntr = &whalewatcher.Container{}
+		NewCompositePacker().
+			Register("", valuePacker{value: 42}).
+			Register("foo", valuePacker{value: "bar"}).
+			Register("", appendingPacker{value: 666}).
+			Pack(cntr, nil)
+		Expect(cntr.Rucksack).To(Equal(MultiRucksack{"": []interface{}{42, 666}, "foo": "bar"}))
+	})
+
+	It("sets up single and multiple packers", func() {
+		pw := NewPodmanWatcher(context.Background(), WithRucksackPacker(valuePacker{value: 42}))
+		defer pw.Close()
+		Expect(pw.packer).To(Equal(valuePacker{value: 42}))
+
+		pw = NewPodmanWatcher(context.Background(), WithKeyedRucksackPacker("foo", valuePacker{value: 42}))
+		defer pw.Close()
+		Expect(pw.packer).To(BeAssignableToTypeOf(&CompositePacker{}))
+
+		pw = NewPodmanWatcher(context.Background(),
+			WithRucksackPacker(valuePacker{value: 42}),
+			WithKeyedRucksackPacker("foo", valuePacker{value: "bar"}))
+		defer pw.Close()
+		Expect(pw.packer).To(BeAssignableToTypeOf(&CompositePacker{}))
+		Expect(pw.packer.(*CompositePacker).packers).To(HaveLen(2))
+
+		pw = NewPodmanWatcher(context.Background(),
+			WithRucksackPacker(valuePacker{value: 42}),
+			WithRucksackPacker(valuePacker{value: "bar"}))
+		defer pw.Close()
+		Expect(pw.packer).To(BeAssignableToTypeOf(&chainedPacker{}))
+		Expect(pw.packer.(*chainedPacker).packers).To(HaveLen(2))
+
+		pw = NewPodmanWatcher(context.Background())
+		defer pw.Close()
+		Expect(pw.packer).To(BeNil())
+	})
+
+})
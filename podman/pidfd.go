@@ -0,0 +1,243 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jellydator/ttlcache/v3"
+	"golang.org/x/sys/unix"
+)
+
+// earlyExitTTL is how long containers that have been reported as exited early
+// are remembered, waiting for their Podman “died” events. This avoids
+// remembering containers forever in case their “died” events got lost.
+const earlyExitTTL = time.Minute
+
+// WithEarlyExitDetection detects the termination of containers instantly,
+// using pidfds for the initial processes of alive containers. As Podman's
+// “died” events only arrive after conmon and the container cleanup have
+// finished, an early ContainerExited lifecycle event then gets emitted as soon
+// as a container's initial process has terminated; the later “died” event
+// gets swallowed.
+//
+// Early exit detection works only for local Podman engines and requires Linux
+// 5.3 or later; otherwise, it is silently disabled. It has no effect in full
+// inventory mode, see [WithFullInventory], nor when polling the container list
+// because Podman doesn't emit any events. When combined with
+// [WithCgroupEvents], a container's exit is reported only once, by whichever
+// detects it first.
+func WithEarlyExitDetection() NewOption {
+	return func(pw *PodmanWatcher) {
+		pw.pidfds = newPidfdMonitor("/proc")
+	}
+}
+
+// earlyExits returns true if early exit detection is enabled and applicable.
+func (pw *PodmanWatcher) earlyExits() bool {
+	return pw.pidfds != nil && !pw.inventory && pw.isLocal()
+}
+
+// earlyExitEvents returns the channel receiving the IDs of containers whose
+// exits have been detected early, or nil if early exit detection isn't
+// enabled.
+func (pw *PodmanWatcher) earlyExitEvents() <-chan string {
+	if !pw.earlyExits() {
+		return nil
+	}
+	return pw.pidfds.exits
+}
+
+// pidfdMonitor monitors the initial processes of containers for their
+// termination, using pidfds.
+type pidfdMonitor struct {
+	procroot string
+	exits    chan string   // IDs of containers that have exited.
+	done     chan struct{} // closed when the monitor is closed.
+
+	mu      sync.Mutex
+	watches map[string]*pidfdWatch // container ID -> watch
+	closed  bool
+
+	exited *ttlcache.Cache[string, struct{}] // IDs of containers whose “died” events are yet to come.
+}
+
+// pidfdWatch is the pidfd for the initial process of a particular container.
+type pidfdWatch struct {
+	pid int
+	f   *os.File
+}
+
+// newPidfdMonitor returns a new pidfd monitor, using the proc filesystem
+// mounted at procroot in order to verify the identity of processes.
+func newPidfdMonitor(procroot string) *pidfdMonitor {
+	m := &pidfdMonitor{
+		procroot: procroot,
+		exits:    make(chan string, 16),
+		done:     make(chan struct{}),
+		watches:  map[string]*pidfdWatch{},
+		exited: ttlcache.New(
+			ttlcache.WithTTL[string, struct{}](earlyExitTTL),
+			ttlcache.WithDisableTouchOnHit[string, struct{}]()),
+	}
+	go m.exited.Start()
+	return m
+}
+
+// watch the initial process with the specified PID of the container with the
+// specified ID, where the process must be in the specified cgroup. In case
+// the PID has already been reused by a process outside the container's
+// cgroup, the container isn't watched at all, as it has already terminated
+// and Podman's “died” event is on its way. Otherwise, any earlier early exit
+// of the container is forgotten, as the container has been restarted.
+func (m *pidfdMonitor) watch(id string, pid int, cgrouppath string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed || pid == 0 || cgrouppath == "" {
+		return
+	}
+	if w, ok := m.watches[id]; ok {
+		if w.pid == pid {
+			return
+		}
+		w.f.Close()
+		delete(m.watches, id)
+	}
+	fd, err := unix.PidfdOpen(pid, 0)
+	if err != nil {
+		return
+	}
+	// Only after we've opened the pidfd we can verify that it refers to the
+	// container's process, as opposed to some process that reused the PID of
+	// the container's already terminated initial process.
+	if !inCgroup(m.procroot, pid, cgrouppath) {
+		unix.Close(fd)
+		return
+	}
+	// Make the pidfd pollable using Go's runtime poller.
+	if err := unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return
+	}
+	w := &pidfdWatch{pid: pid, f: os.NewFile(uintptr(fd), "pidfd:"+strconv.Itoa(pid))}
+	m.watches[id] = w
+	m.exited.Delete(id)
+	go m.await(id, w)
+}
+
+// await the termination of the process of the specified pidfd watch, then
+// reporting the container as exited. If the watch gets closed beforehand,
+// await returns without reporting anything.
+func (m *pidfdMonitor) await(id string, w *pidfdWatch) {
+	conn, err := w.f.SyscallConn()
+	if err != nil {
+		return
+	}
+	// A pidfd becomes readable when its process has terminated, so we need to
+	// wait for readability. The read function is called once before waiting,
+	// so we have to skip this first call.
+	polled := false
+	err = conn.Read(func(uintptr) bool {
+		done := polled
+		polled = true
+		return done
+	})
+	if err != nil {
+		return // watch has been closed.
+	}
+	m.mu.Lock()
+	if m.watches[id] != w {
+		m.mu.Unlock()
+		return
+	}
+	delete(m.watches, id)
+	w.f.Close()
+	m.exited.Set(id, struct{}{}, ttlcache.DefaultTTL)
+	m.mu.Unlock()
+	select {
+	case m.exits <- id:
+	case <-m.done:
+	}
+}
+
+// exitedEarly returns true if the container with the specified ID has already
+// been reported as exited, with Podman's “died” event yet to come.
+func (m *pidfdMonitor) exitedEarly(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.exited.Get(id) != nil
+}
+
+// died returns true if the container with the specified ID has already been
+// reported as exited, so that the Podman “died” event needs to be swallowed.
+// Otherwise, died stops watching the container.
+func (m *pidfdMonitor) died(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.exited.Get(id) != nil {
+		m.exited.Delete(id)
+		return true
+	}
+	if w, ok := m.watches[id]; ok {
+		w.f.Close()
+		delete(m.watches, id)
+	}
+	return false
+}
+
+// close the monitor, stopping watching all containers.
+func (m *pidfdMonitor) close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return
+	}
+	m.closed = true
+	close(m.done)
+	m.exited.Stop()
+	for id, w := range m.watches {
+		w.f.Close()
+		delete(m.watches, id)
+	}
+}
+
+// inCgroup returns true if the process with the specified PID is in the
+// specified cgroup or one of its child cgroups.
+func inCgroup(procroot string, pid int, cgrouppath string) bool {
+	f, err := os.Open(procroot + "/" + strconv.Itoa(pid) + "/cgroup")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	cgrouppath = strings.TrimSuffix(cgrouppath, "/")
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Lines are in the format "hierarchy-ID:controller-list:cgroup-path".
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		path := fields[2]
+		if path == cgrouppath || strings.HasPrefix(path, cgrouppath+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jellydator/ttlcache/v3"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/thediveo/success"
+)
+
+// ownCgroup returns the (unified hierarchy) cgroup path of this process.
+func ownCgroup() string {
+	GinkgoHelper()
+	for _, line := range strings.Split(string(Successful(os.ReadFile("/proc/self/cgroup"))), "\n") {
+		if strings.HasPrefix(line, "0::") {
+			return strings.TrimPrefix(line, "0::")
+		}
+	}
+	Skip("needs cgroup v2")
+	return ""
+}
+
+var _ = Describe("early exit detection", func() {
+
+	It("checks cgroup membership", func() {
+		procroot := GinkgoT().TempDir()
+		Expect(os.MkdirAll(filepath.Join(procroot, "42"), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(procroot, "42", "cgroup"), []byte(
+			"12:pids:/machine.slice/libpod-1234.scope/container\n"+
+				"0::/machine.slice/libpod-1234.scope/container\n"), 0644)).To(Succeed())
+		Expect(inCgroup(procroot, 42, "/machine.slice/libpod-1234.scope")).To(BeTrue())
+		Expect(inCgroup(procroot, 42, "/machine.slice/libpod-1234.scope/")).To(BeTrue())
+		Expect(inCgroup(procroot, 42, "/machine.slice/libpod-12")).To(BeFalse())
+		Expect(inCgroup(procroot, 666, "/machine.slice")).To(BeFalse())
+	})
+
+	It("is only applicable to local engines outside inventory mode", func() {
+		pw := &PodmanWatcher{}
+		Expect(pw.earlyExits()).To(BeFalse())
+		Expect(pw.earlyExitEvents()).To(BeNil())
+	})
+
+	It("detects process termination and swallows the late died event", func() {
+		cgroup := ownCgroup()
+		cmd := exec.Command("sleep", "60")
+		Expect(cmd.Start()).To(Succeed())
+		defer func() { _ = cmd.Process.Kill(); _ = cmd.Wait() }()
+
+		m := newPidfdMonitor("/proc")
+		defer m.close()
+		m.watch("1234", cmd.Process.Pid, cgroup)
+		m.mu.Lock()
+		watching := len(m.watches)
+		m.mu.Unlock()
+		if watching == 0 {
+			Skip("needs pidfd support")
+		}
+
+		Consistently(m.exits).ShouldNot(Receive())
+		Expect(cmd.Process.Kill()).To(Succeed())
+		Eventually(m.exits).Should(Receive(Equal("1234")))
+		Expect(m.exitedEarly("1234")).To(BeTrue())
+		Expect(m.died("1234")).To(BeTrue())
+		Expect(m.exitedEarly("1234")).To(BeFalse())
+		Expect(m.died("1234")).To(BeFalse())
+	})
+
+	It("forgets early exits", func() {
+		cgroup := ownCgroup()
+		cmd := exec.Command("sleep", "60")
+		Expect(cmd.Start()).To(Succeed())
+		defer func() { _ = cmd.Process.Kill(); _ = cmd.Wait() }()
+
+		m := newPidfdMonitor("/proc")
+		defer m.close()
+
+		By("expiring early exits with missed died events")
+		m.exited.Set("1234", struct{}{}, 50*time.Millisecond)
+		Expect(m.exitedEarly("1234")).To(BeTrue())
+		Eventually(m.exitedEarly).WithArguments("1234").Should(BeFalse())
+
+		By("forgetting early exits of restarted containers")
+		m.exited.Set("1234", struct{}{}, ttlcache.DefaultTTL)
+		m.watch("1234", cmd.Process.Pid, cgroup)
+		m.mu.Lock()
+		watching := len(m.watches)
+		m.mu.Unlock()
+		if watching == 0 {
+			Skip("needs pidfd support")
+		}
+		Expect(m.exitedEarly("1234")).To(BeFalse())
+		Expect(m.died("1234")).To(BeFalse())
+	})
+
+	It("stops early detection when polling", func() {
+		pw := &PodmanWatcher{
+			pidfds:  newPidfdMonitor("/proc"),
+			cgroups: newCgroupMonitor(DefaultCgroupRoot, nil),
+		}
+		pw.stopEarlyDetection()
+		Expect(pw.pidfds.closed).To(BeTrue())
+		Expect(pw.cgroups.closed).To(BeTrue())
+		Expect(pw.pidfds.done).To(BeClosed())
+		pw.Close()
+	})
+
+	It("doesn't watch processes outside the container's cgroup", func() {
+		cmd := exec.Command("sleep", "60")
+		Expect(cmd.Start()).To(Succeed())
+		defer func() { _ = cmd.Process.Kill(); _ = cmd.Wait() }()
+
+		m := newPidfdMonitor("/proc")
+		defer m.close()
+		m.watch("1234", cmd.Process.Pid, "/machine.slice/libpod-1234.scope")
+		Expect(m.watches).To(BeEmpty())
+	})
+
+	It("stops watching when the died event arrives first", func() {
+		cgroup := ownCgroup()
+		cmd := exec.Command("sleep", "60")
+		Expect(cmd.Start()).To(Succeed())
+		defer func() { _ = cmd.Process.Kill(); _ = cmd.Wait() }()
+
+		m := newPidfdMonitor("/proc")
+		defer m.close()
+		m.watch("1234", cmd.Process.Pid, cgroup)
+		Expect(m.died("1234")).To(BeFalse())
+		Expect(m.watches).To(BeEmpty())
+		Expect(cmd.Process.Kill()).To(Succeed())
+		Consistently(m.exits, 250*time.Millisecond).ShouldNot(Receive())
+	})
+
+})
@@ -0,0 +1,281 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thediveo/whalewatcher"
+)
+
+// WithPIDTranslation translates the PIDs of containers from the PID namespace
+// of the Podman engine into the PID namespace of the caller, which is
+// necessary when the caller runs inside its own PID namespace, such as inside
+// a container. Translated containers carry their original PID in the engine's
+// PID namespace in the [EnginePIDLabelName] label.
+//
+// Containers whose processes aren't visible from the caller's PID namespace
+// keep their PIDs untranslated, but are flagged using the
+// [PIDInvisibleLabelName] label. For PID translation to work correctly when
+// the Podman engine runs in a different PID namespace than the caller, the
+// engine's PID as seen from the caller must be known, see [WithPID].
+func WithPIDTranslation() NewOption {
+	return func(pw *PodmanWatcher) {
+		pw.pidxlate = "/proc"
+	}
+}
+
+// translatePID translates the PID of the specified container from the
+// engine's PID namespace into the caller's PID namespace, using the proc
+// filesystem mounted at procroot. The container's start time allows skipping
+// rescanning the proc filesystem for containers that should have been found
+// already.
+func (pw *PodmanWatcher) translatePID(procroot string, cntr *whalewatcher.Container, started time.Time) {
+	if cntr.PID == 0 {
+		return
+	}
+	pid, ok := pw.pidTranslator(procroot).translate(cntr.PID, cntr.ID, started)
+	if !ok {
+		cntr.Labels[PIDInvisibleLabelName] = ""
+		return
+	}
+	if pid != cntr.PID {
+		cntr.Labels[EnginePIDLabelName] = strconv.Itoa(cntr.PID)
+		cntr.PID = pid
+	}
+}
+
+// pidTranslator returns the PID translator for the proc filesystem mounted at
+// procroot, creating it on first use.
+func (pw *PodmanWatcher) pidTranslator(procroot string) *pidTranslator {
+	pw.xmu.Lock()
+	defer pw.xmu.Unlock()
+	if pw.pidtable == nil || pw.pidtable.procroot != procroot {
+		pw.pidtable = newPIDTranslator(procroot, pw.pid)
+	}
+	return pw.pidtable
+}
+
+// hasVisiblePID returns true if the specified container has a PID that refers
+// to the container's initial process as seen from the caller's PID namespace.
+// It returns false if the container has no PID or if PID translation flagged
+// the container's PID as invisible.
+func hasVisiblePID(cntr *whalewatcher.Container) bool {
+	if cntr.PID == 0 {
+		return false
+	}
+	_, invisible := cntr.Labels[PIDInvisibleLabelName]
+	return !invisible
+}
+
+// pidTranslator translates the PIDs of the initial processes of containers
+// from the PID namespace of an engine into the PID namespace of a proc
+// filesystem.
+//
+// The PIDs of a process in the PID namespaces it is a member of are listed in
+// the “NSpid” field of /proc/[PID]/status, starting with the PID in the PID
+// namespace of the proc filesystem. As the engine might be in a nested PID
+// namespace, the engine's PID namespace nesting level determines which NSpid
+// field to look at. Because there might be multiple PID namespaces at the
+// same nesting level, a matching process must additionally be in the engine's
+// PID namespace or be a child of a process in the engine's PID namespace, as
+// container processes are children of conmon processes. Finally, a matching
+// process must be in the container's libpod cgroup.
+//
+// For a nested engine PID namespace, the translator keeps a table of the
+// container processes found when scanning the proc filesystem, so that
+// translating the PIDs of many containers, such as when listing them, scans
+// the proc filesystem only once.
+type pidTranslator struct {
+	procroot  string
+	enginepid int // engine PID as seen from the caller, or 0 if unknown.
+
+	mu    sync.Mutex
+	built time.Time            // when the table was last built.
+	table map[containerPID]int // container PIDs in the proc filesystem's PID namespace.
+}
+
+// containerPID is the PID of a container process in the engine's PID
+// namespace.
+type containerPID struct {
+	id  string // container ID.
+	pid int    // PID in the engine's PID namespace.
+}
+
+// newPIDTranslator returns a new PID translator for the proc filesystem
+// mounted at procroot and the engine with the specified PID (as seen from the
+// caller, or 0 if unknown).
+func newPIDTranslator(procroot string, enginepid int) *pidTranslator {
+	return &pidTranslator{
+		procroot:  procroot,
+		enginepid: enginepid,
+	}
+}
+
+// translate the specified PID of the initial process of the container with
+// the specified ID and start time from the engine's PID namespace into the
+// PID namespace of the proc filesystem.
+func (x *pidTranslator) translate(pid int, id string, started time.Time) (int, bool) {
+	level, enginens, ok := x.engineNamespace()
+	if !ok {
+		return 0, false
+	}
+	if level == 0 {
+		// Same PID namespace nesting level, so we can directly check the
+		// candidate, without having to scan through all processes.
+		if nspid := procNSpid(x.procroot, strconv.Itoa(pid)); len(nspid) > 0 &&
+			procContainerID(x.procroot, pid) == id && x.inEngineNamespace(pid, enginens) {
+			return pid, true
+		}
+		return 0, false
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	key := containerPID{id: id, pid: pid}
+	candidate, ok := x.table[key]
+	if ok && x.isContainerProc(candidate, level, key) {
+		return candidate, true
+	}
+	// Only rescan if the table might be stale: either it contains a stale
+	// entry for the container, or it was built before the container started
+	// (or we don't know when the container started).
+	if !ok && x.table != nil && !started.IsZero() && x.built.After(started) {
+		return 0, false
+	}
+	x.rebuild(level, enginens)
+	candidate, ok = x.table[key]
+	return candidate, ok
+}
+
+// engineNamespace returns the PID namespace nesting level of the engine
+// relative to the PID namespace of the proc filesystem, as well as the
+// identifier of the engine's PID namespace.
+func (x *pidTranslator) engineNamespace() (level int, enginens string, ok bool) {
+	self := "self"
+	if x.enginepid != 0 {
+		self = strconv.Itoa(x.enginepid)
+	}
+	enginensnspid := procNSpid(x.procroot, self)
+	if len(enginensnspid) == 0 {
+		return 0, "", false
+	}
+	enginens, err := os.Readlink(x.procroot + "/" + self + "/ns/pid")
+	if err != nil {
+		return 0, "", false
+	}
+	return len(enginensnspid) - 1, enginens, true
+}
+
+// inEngineNamespace returns true if the specified process is in the engine's
+// PID namespace or a child of a process in the engine's PID namespace.
+func (x *pidTranslator) inEngineNamespace(pid int, enginens string) bool {
+	if procPIDNamespace(x.procroot, pid) == enginens {
+		return true
+	}
+	ppid := procPPID(x.procroot, pid)
+	return ppid != 0 && procPIDNamespace(x.procroot, ppid) == enginens
+}
+
+// isContainerProc returns true if the specified process (still) is the
+// specified container process, as listed in the table.
+func (x *pidTranslator) isContainerProc(pid int, level int, key containerPID) bool {
+	nspid := procNSpid(x.procroot, strconv.Itoa(pid))
+	return len(nspid) > level && nspid[level] == key.pid &&
+		procContainerID(x.procroot, pid) == key.id
+}
+
+// rebuild the table of container processes by scanning the proc filesystem.
+// It must be called with the lock held.
+func (x *pidTranslator) rebuild(level int, enginens string) {
+	x.table = map[containerPID]int{}
+	x.built = time.Now()
+	entries, err := os.ReadDir(x.procroot)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		candidate, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		nspid := procNSpid(x.procroot, entry.Name())
+		if len(nspid) <= level {
+			continue
+		}
+		id := procContainerID(x.procroot, candidate)
+		if id == "" || !x.inEngineNamespace(candidate, enginens) {
+			continue
+		}
+		x.table[containerPID{id: id, pid: nspid[level]}] = candidate
+	}
+}
+
+// procNSpid returns the PIDs of the specified process in all PID namespaces it
+// is a member of, as listed in the NSpid field of /proc/[PID]/status. It
+// returns nil if the process doesn't exist or in case of errors.
+func procNSpid(procroot string, pid string) []int {
+	f, err := os.Open(procroot + "/" + pid + "/status")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "NSpid:") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, "NSpid:"))
+		nspid := make([]int, 0, len(fields))
+		for _, field := range fields {
+			p, err := strconv.Atoi(field)
+			if err != nil {
+				return nil
+			}
+			nspid = append(nspid, p)
+		}
+		return nspid
+	}
+	return nil
+}
+
+// procPIDNamespace returns the identifier of the PID namespace of the
+// specified process, or "" in case of errors.
+func procPIDNamespace(procroot string, pid int) string {
+	ns, err := os.Readlink(procroot + "/" + strconv.Itoa(pid) + "/ns/pid")
+	if err != nil {
+		return ""
+	}
+	return ns
+}
+
+// procPPID returns the parent PID of the specified process, or 0 in case of
+// errors.
+func procPPID(procroot string, pid int) int {
+	stat, err := os.ReadFile(procroot + "/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return 0
+	}
+	proc, err := parseProcStat(stat)
+	if err != nil {
+		return 0
+	}
+	return proc.ppid
+}
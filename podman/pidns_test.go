@@ -0,0 +1,185 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thediveo/whalewatcher"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// fakeNSProc adds a process with the specified PID, PPID, PID namespace and
+// NSpid list to the fake procfs at procroot.
+func fakeNSProc(procroot string, pid string, ppid int, pidns string, nspid ...int) {
+	GinkgoHelper()
+	dir := filepath.Join(procroot, pid)
+	Expect(os.MkdirAll(filepath.Join(dir, "ns"), 0755)).To(Succeed())
+	Expect(os.WriteFile(filepath.Join(dir, "stat"),
+		[]byte(fmt.Sprintf("%s (proc) S %d 1 1 0 -1\n", pid, ppid)), 0644)).To(Succeed())
+	pids := make([]string, 0, len(nspid))
+	for _, p := range nspid {
+		pids = append(pids, strconv.Itoa(p))
+	}
+	Expect(os.WriteFile(filepath.Join(dir, "status"),
+		[]byte("Name:\tproc\nTgid:\t"+pid+"\nNSpid:\t"+strings.Join(pids, "\t")+"\n"), 0644)).To(Succeed())
+	Expect(os.Symlink("pid:["+pidns+"]", filepath.Join(dir, "ns", "pid"))).To(Succeed())
+}
+
+var _ = Describe("PID translation", func() {
+
+	id := strings.Repeat("1", 64)
+	otherid := strings.Repeat("2", 64)
+
+	libpodCgroup := func(id string) string {
+		return "/machine.slice/libpod-" + id + ".scope/container"
+	}
+
+	var procroot string
+
+	BeforeEach(func() {
+		procroot = GinkgoT().TempDir()
+		fakeNSProc(procroot, "1", 0, "1", 1)
+		fakeNSProc(procroot, "self", 1, "1", 50)
+	})
+
+	It("reads NSpid", func() {
+		fakeNSProc(procroot, "100", 1, "2", 100, 1)
+		Expect(procNSpid(procroot, "100")).To(HaveExactElements(100, 1))
+		Expect(procNSpid(procroot, "666")).To(BeNil())
+		Expect(procPPID(procroot, 100)).To(Equal(1))
+		Expect(procPPID(procroot, 666)).To(BeZero())
+		Expect(procPIDNamespace(procroot, 100)).To(Equal("pid:[2]"))
+	})
+
+	It("tells visible PIDs", func() {
+		Expect(hasVisiblePID(&whalewatcher.Container{PID: 42, Labels: map[string]string{}})).To(BeTrue())
+		Expect(hasVisiblePID(&whalewatcher.Container{PID: 0, Labels: map[string]string{}})).To(BeFalse())
+		Expect(hasVisiblePID(&whalewatcher.Container{PID: 42, Labels: map[string]string{
+			PIDInvisibleLabelName: "",
+		}})).To(BeFalse())
+	})
+
+	It("keeps PIDs when in the same PID namespace", func() {
+		fakeNSProc(procroot, "90", 1, "1", 90)
+		fakeNSProc(procroot, "100", 90, "2", 100, 1)
+		fakeCgroup(procroot, 100, libpodCgroup(id))
+		// decoy outside any container cgroup.
+		fakeNSProc(procroot, "110", 90, "2", 110, 1)
+
+		x := newPIDTranslator(procroot, 0)
+		pid, ok := x.translate(100, id, time.Time{})
+		Expect(ok).To(BeTrue())
+		Expect(pid).To(Equal(100))
+		_, ok = x.translate(100, otherid, time.Time{})
+		Expect(ok).To(BeFalse())
+		_, ok = x.translate(110, id, time.Time{})
+		Expect(ok).To(BeFalse())
+		_, ok = x.translate(666, id, time.Time{})
+		Expect(ok).To(BeFalse())
+		Expect(x.table).To(BeNil())
+	})
+
+	It("translates PIDs from a nested engine PID namespace", func() {
+		fakeNSProc(procroot, "10", 1, "3", 10, 1)
+		// conmon and container in the engine's PID namespace.
+		fakeNSProc(procroot, "190", 1, "3", 190, 41)
+		fakeNSProc(procroot, "200", 190, "4", 200, 42, 1)
+		fakeCgroup(procroot, 200, libpodCgroup(id))
+		// decoy in a sibling PID namespace at the same nesting level.
+		fakeNSProc(procroot, "300", 1, "5", 300, 42)
+		fakeCgroup(procroot, 300, libpodCgroup(id))
+		// decoy in the engine's PID namespace, but in another container's
+		// cgroup.
+		fakeNSProc(procroot, "400", 1, "3", 400, 43)
+		fakeCgroup(procroot, 400, libpodCgroup(otherid))
+		// decoy in the engine's PID namespace, but outside any container
+		// cgroup.
+		fakeNSProc(procroot, "500", 1, "3", 500, 44)
+
+		x := newPIDTranslator(procroot, 10)
+		pid, ok := x.translate(42, id, time.Time{})
+		Expect(ok).To(BeTrue())
+		Expect(pid).To(Equal(200))
+		_, ok = x.translate(43, id, time.Time{})
+		Expect(ok).To(BeFalse())
+		_, ok = x.translate(44, id, time.Time{})
+		Expect(ok).To(BeFalse())
+		_, ok = newPIDTranslator(procroot, 666).translate(42, id, time.Time{})
+		Expect(ok).To(BeFalse())
+	})
+
+	It("scans the proc filesystem only when necessary", func() {
+		fakeNSProc(procroot, "10", 1, "3", 10, 1)
+		fakeNSProc(procroot, "200", 10, "4", 200, 42, 1)
+		fakeCgroup(procroot, 200, libpodCgroup(id))
+
+		x := newPIDTranslator(procroot, 10)
+		past := time.Now().Add(-time.Hour)
+		pid, ok := x.translate(42, id, past)
+		Expect(ok).To(BeTrue())
+		Expect(pid).To(Equal(200))
+		built := x.built
+
+		// a container that started before the table was built must already
+		// be in the table.
+		fakeNSProc(procroot, "210", 10, "5", 210, 43, 1)
+		fakeCgroup(procroot, 210, libpodCgroup(otherid))
+		_, ok = x.translate(43, otherid, past)
+		Expect(ok).To(BeFalse())
+		Expect(x.built).To(Equal(built))
+		pid, ok = x.translate(42, id, past)
+		Expect(ok).To(BeTrue())
+		Expect(pid).To(Equal(200))
+		Expect(x.built).To(Equal(built))
+
+		// a container that started after the table was built needs a rescan.
+		pid, ok = x.translate(43, otherid, time.Now().Add(time.Hour))
+		Expect(ok).To(BeTrue())
+		Expect(pid).To(Equal(210))
+		Expect(x.built).NotTo(Equal(built))
+	})
+
+	It("annotates translated and invisible containers", func() {
+		fakeNSProc(procroot, "10", 1, "3", 10, 1)
+		fakeNSProc(procroot, "190", 1, "3", 190, 41)
+		fakeNSProc(procroot, "200", 190, "4", 200, 42, 1)
+		fakeCgroup(procroot, 200, libpodCgroup(id))
+
+		pw := &PodmanWatcher{pid: 10}
+		WithPIDTranslation()(pw)
+		Expect(pw.pidxlate).To(Equal("/proc"))
+
+		cntr := &whalewatcher.Container{ID: id, PID: 42, Labels: map[string]string{}}
+		pw.translatePID(procroot, cntr, time.Time{})
+		Expect(cntr.PID).To(Equal(200))
+		Expect(cntr.Labels).To(HaveKeyWithValue(EnginePIDLabelName, "42"))
+		Expect(cntr.Labels).NotTo(HaveKey(PIDInvisibleLabelName))
+
+		cntr = &whalewatcher.Container{ID: id, PID: 666, Labels: map[string]string{}}
+		pw.translatePID(procroot, cntr, time.Time{})
+		Expect(cntr.PID).To(Equal(666))
+		Expect(cntr.Labels).To(HaveKey(PIDInvisibleLabelName))
+		Expect(cntr.Labels).NotTo(HaveKey(EnginePIDLabelName))
+	})
+
+})
@@ -19,6 +19,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/containers/podman/v4/libpod/define"
 	"github.com/containers/podman/v4/pkg/bindings"
 	"github.com/containers/podman/v4/pkg/bindings/containers"
 	"github.com/containers/podman/v4/pkg/bindings/pods"
@@ -42,6 +43,30 @@ const (
 	PodLabelName   = PodmanAnnotation + "podname" // name of pod if applicable
 	PodIDName      = PodmanAnnotation + "podid"   // ID of pod if applicable
 	InfraLabelName = PodmanAnnotation + "infra"   // present only if container is an infra container
+
+	UIDMapLabelName = PodmanAnnotation + "uidmap" // UID mappings if container is in its own user namespace
+	GIDMapLabelName = PodmanAnnotation + "gidmap" // GID mappings if container is in its own user namespace
+
+	ImageNameLabelName     = PodmanAnnotation + "imagename"     // image reference name
+	ImageIDLabelName       = PodmanAnnotation + "imageid"       // image ID
+	ImageDigestLabelName   = PodmanAnnotation + "imagedigest"   // image repo digest in "repo@digest" form, if known
+	ImagePlatformLabelName = PodmanAnnotation + "imageplatform" // image os/arch, if known
+
+	InfraIDLabelName    = PodmanAnnotation + "infraid"    // ID of pod's infra container, if folded
+	InfraPIDLabelName   = PodmanAnnotation + "infrapid"   // PID of pod's infra container, if folded
+	InfraNetNSLabelName = PodmanAnnotation + "infranetns" // network namespace path of pod's infra container, if folded
+
+	StateLabelName = PodmanAnnotation + "state" // container state, in full inventory mode only
+
+	RestoredLabelName         = PodmanAnnotation + "restored"         // time of restore, if restored from a checkpoint
+	CheckpointOriginLabelName = PodmanAnnotation + "checkpointorigin" // ID of checkpointed container, if known
+
+	BundleLabelName = PodmanAnnotation + "bundle" // OCI bundle path, only for containers discovered via conmon
+
+	EnginePIDLabelName    = PodmanAnnotation + "enginepid"    // PID in the engine's PID namespace, if translated
+	PIDInvisibleLabelName = PodmanAnnotation + "pidinvisible" // present only if container PID isn't visible to the caller
+
+	ParentContainerLabelName = PodmanAnnotation + "parentcontainer" // ID of container a nested engine runs in, if nested
 )
 
 // PodmanWatcher is a Podman EngineClient for interfacing the generic whale
@@ -50,13 +75,49 @@ const (
 // process dead (line) just to justify not having to call it "daemon" because it
 // doesn't run constantly in the background. Unless someone watches a podman.
 type PodmanWatcher struct { //revive:disable-line:exported
-	pid      int                             // optional engine PID when known.
-	podman   context.Context                 // (minimal) moby engine API client ... which is actually a context?!
-	packer   engineclient.RucksackPacker     // optional Rucksack packer for app-specific container information.
-	podcache *ttlcache.Cache[string, string] // pod ID->name TTL cache
+	pid           int                             // optional engine PID when known.
+	podman        context.Context                 // (minimal) moby engine API client ... which is actually a context?!
+	cmu           sync.RWMutex                    // protects podman in case of lazy connections.
+	lmu           sync.Mutex                      // serializes lazily connecting.
+	connect       func() (context.Context, error) // optional lazy connection.
+	lazysock      string                          // URI of lazy connection.
+	waitsocket    bool                            // lazy connection waits for socket.
+	packer        engineclient.RucksackPacker     // optional Rucksack packer for app-specific container information.
+	packers       []keyedPacker                   // Rucksack packers as specified in options.
+	onpackerpanic func(PackerPanic)               // optional notification of panicking packers.
+	podcache      *ttlcache.Cache[string, string] // pod ID->name TTL cache
+
+	checkpoints *ttlcache.Cache[string, string] // checkpointed container ID/name->ID
+
+	idmappings bool                                    // annotate containers with their user namespace ID mappings.
+	images     bool                                    // annotate containers with their image identity.
+	networks   bool                                    // pack network information into container Rucksacks.
+	selector   *Selector                               // optional selection of containers to watch.
+	inframode  InfraMode                               // how to handle infra containers.
+	inventory  bool                                    // track all containers, not only alive ones.
+	execs      *execSessions                           // optional exec session tracking.
+	resync     *tracker                                // optional periodic resynchronization.
+	eventslog  string                                  // optional events log file to tail.
+	pidfds     *pidfdMonitor                           // optional early exit detection.
+	cgroups    *cgroupMonitor                          // optional cgroup events cross-checking.
+	pidxlate   string                                  // procfs for PID translation, if enabled.
+	parent     string                                  // ID of container this engine runs in, if nested.
+	imagecache *ttlcache.Cache[string, *imageIdentity] // image ID->identity TTL cache
+	netcache   *ttlcache.Cache[string, *NetworkInfo]   // network-sharing container ID->network info TTL cache
+	infracache *ttlcache.Cache[string, *infraDetails]  // pod ID->infra container details TTL cache
 
 	vmu     sync.Mutex
 	version string // cached version information
+
+	imu  sync.Mutex
+	info *define.Info // cached engine information
+
+	xmu      sync.Mutex
+	pidtable *pidTranslator // lazily created PID translator.
+
+	pmu          sync.Mutex
+	poll         *tracker      // lazily allocated polling tracker in case of no engine events.
+	pollinterval time.Duration // interval for polling in case of no engine events.
 }
 
 // Make sure that the EngineClient interface is fully implemented.
@@ -71,11 +132,34 @@ func NewPodmanWatcher(podman context.Context, opts ...NewOption) *PodmanWatcher
 	pw := &PodmanWatcher{
 		podman:   podman,
 		podcache: ttlcache.New(ttlcache.WithTTL[string, string](1 * time.Minute)),
+		checkpoints: ttlcache.New(
+			ttlcache.WithTTL[string, string](checkpointTTL),
+			ttlcache.WithDisableTouchOnHit[string, string]()),
+		pollinterval: DefaultPollInterval,
 	}
 	for _, opt := range opts {
 		opt(pw)
 	}
+	pw.packer = newPacker(pw.packers, pw.onpackerpanic)
+	if pw.cgroups != nil {
+		// In full inventory mode, exits are signalled by Podman's refreshing
+		// “died” events instead.
+		pw.cgroups.exits = !pw.inventory
+	}
 	go pw.podcache.Start()
+	go pw.checkpoints.Start()
+	if pw.images {
+		pw.imagecache = ttlcache.New(ttlcache.WithTTL[string, *imageIdentity](5 * time.Minute))
+		go pw.imagecache.Start()
+	}
+	if pw.networks {
+		pw.netcache = ttlcache.New(ttlcache.WithTTL[string, *NetworkInfo](1 * time.Minute))
+		go pw.netcache.Start()
+	}
+	if pw.inframode == InfraFold {
+		pw.infracache = ttlcache.New(ttlcache.WithTTL[string, *infraDetails](1 * time.Minute))
+		go pw.infracache.Start()
+	}
 	return pw
 }
 
@@ -92,11 +176,93 @@ func WithPID(pid int) NewOption {
 
 // WithRucksackPacker sets the Rucksack packer that adds application-specific
 // container information based on the inspected container data. The specified
-// Rucksack packer gets passed the inspection data in form of a Docker client
-// types.ContainerJSON.
+// Rucksack packer gets passed the inspection data in form of a Podman
+// *define.InspectContainerData. Use the [DetailsPacker] in order to pack
+// selected container details in a stable form that doesn't depend on Podman's
+// vendored types.
+//
+// WithRucksackPacker can be specified multiple times, as can be
+// [WithKeyedRucksackPacker]. Multiple packers specified only using
+// WithRucksackPacker are chained in the order specified on the same plain
+// Rucksack: each packer gets passed the Rucksack as left by the previous
+// packer, so it can add to it. When also keyed packers are specified, the
+// packers run in the order specified and the Rucksack becomes a
+// [MultiRucksack] that stores what each packer packed under the key the
+// packer was specified with; see also [CompositePacker]. Packers specified
+// using WithRucksackPacker then are chained under the empty key "".
+//
+// A plain Rucksack packed by packers specified only using WithRucksackPacker
+// doesn't carry the network information of [WithNetworkInfo], as this would
+// change the Rucksack's type; use [WithKeyedRucksackPacker] instead when
+// needing both.
 func WithRucksackPacker(packer engineclient.RucksackPacker) NewOption {
+	return WithKeyedRucksackPacker("", packer)
+}
+
+// WithKeyedRucksackPacker adds a Rucksack packer that adds
+// application-specific container information, storing it in a [MultiRucksack]
+// under the specified key. Use [UnpackRucksack] with the same key in order to
+// retrieve the packed information.
+func WithKeyedRucksackPacker(key string, packer engineclient.RucksackPacker) NewOption {
 	return func(pw *PodmanWatcher) {
-		pw.packer = packer
+		pw.packers = append(pw.packers, keyedPacker{key: key, packer: packer})
+	}
+}
+
+// WithPackerPanicHandler sets the function to call whenever a Rucksack packer
+// panics. Panicking packers don't take down container inspection, but
+// otherwise would go unnoticed.
+func WithPackerPanicHandler(fn func(PackerPanic)) NewOption {
+	return func(pw *PodmanWatcher) {
+		pw.onpackerpanic = fn
+	}
+}
+
+// WithIDMappings annotates containers running in their own user namespaces
+// with their UID and GID mappings, using the [UIDMapLabelName] and
+// [GIDMapLabelName] labels. Use [ContainerIDMappings] to retrieve the ID
+// mappings in structured form.
+//
+// The ID mappings are taken from the container inspection data when available;
+// otherwise, for engines connected via a local unix socket, they are read from
+// the container's initial process in "/proc/$PID/[ug]id_map".
+func WithIDMappings() NewOption {
+	return func(pw *PodmanWatcher) {
+		pw.idmappings = true
+	}
+}
+
+// WithImageAnnotations annotates containers with the identity of the image
+// they were created from, using the [ImageNameLabelName], [ImageIDLabelName],
+// [ImageDigestLabelName], and [ImagePlatformLabelName] labels. The image
+// details are retrieved using libpod's image inspection and then cached, as
+// usually many containers share the same image.
+//
+// The repo digest is always in "repo@digest" form and only present if the
+// image has a repo digest for the repository of the image's reference name.
+// In particular, locally built images lack repo digests.
+func WithImageAnnotations() NewOption {
+	return func(pw *PodmanWatcher) {
+		pw.images = true
+	}
+}
+
+// WithNetworkInfo packs the networks a container is attached to, as well as its
+// published ports, into the container's Rucksack in form of a [NetworkInfo].
+// Containers sharing the network namespace of another container, such as pod
+// members, get the network information of the other container, that is, of
+// their pod's infra container. Use [ContainerNetworks] to retrieve the network
+// information.
+//
+// WithNetworkInfo never changes the type of a plain Rucksack packed by an
+// application-specific packer set using [WithRucksackPacker]: such containers
+// then don't carry network information. In order to get both, specify the
+// application's packer using [WithKeyedRucksackPacker] with a non-empty key
+// instead, so that the Rucksack becomes a [MultiRucksack] carrying both the
+// application's Rucksack contents and the network information.
+func WithNetworkInfo() NewOption {
+	return func(pw *PodmanWatcher) {
+		pw.networks = true
 	}
 }
 
@@ -124,6 +290,9 @@ func (pw *PodmanWatcher) Version(svcctx context.Context) string {
 
 // Try queries the version of the Podman service and caches the result.
 func (pw *PodmanWatcher) Try(svcctx context.Context) error {
+	if err := pw.connected(svcctx); err != nil {
+		return err
+	}
 	pw.vmu.Lock()
 	defer pw.vmu.Unlock()
 	return pw.fetchVersionUnderLock(svcctx)
@@ -139,17 +308,44 @@ func (pw *PodmanWatcher) fetchVersionUnderLock(svcctx context.Context) error {
 	info, err := system.Version(ctx, nil)
 	if err != nil {
 		pw.version = "unknown"
-		return err
+		return util.Classify(err)
 	}
 	pw.version = info.Server.Version
 	return nil
 }
 
+// Rootless returns true if the Podman engine runs rootless, that is, as an
+// unprivileged user inside its own user namespace. Please note that Rootless
+// returns false if the engine information cannot be retrieved.
+func (pw *PodmanWatcher) Rootless(svcctx context.Context) bool {
+	info, err := pw.engineInfo(svcctx)
+	return err == nil && info.Host != nil && info.Host.Security.Rootless
+}
+
+// engineInfo returns the (cached) Podman engine information. As the Podman
+// Info service is slow, the information is retrieved only once and then
+// cached; errors are not cached.
+func (pw *PodmanWatcher) engineInfo(svcctx context.Context) (*define.Info, error) {
+	pw.imu.Lock()
+	defer pw.imu.Unlock()
+	if pw.info != nil {
+		return pw.info, nil
+	}
+	ctx, release := pw.y(svcctx)
+	defer release()
+	info, err := system.Info(ctx, nil)
+	if err != nil {
+		return nil, util.Classify(err)
+	}
+	pw.info = info
+	return info, nil
+}
+
 // API returns the container engine API path.
 func (pw *PodmanWatcher) API() string {
-	client, err := bindings.GetClient(pw.podman)
+	client, err := bindings.GetClient(pw.conn())
 	if err != nil {
-		return ""
+		return pw.lazysock
 	}
 	return client.URI.String()
 }
@@ -159,34 +355,93 @@ func (pw *PodmanWatcher) PID() int { return pw.pid }
 
 // Client returns the underlying engine client (engine-specific); in case of
 // Podman this is a [context.Context] (sic(k)!) that in turns contains a client.
-func (pw *PodmanWatcher) Client() interface{} { return pw.podman }
+// In case of a lazy connection, Client returns nil until connected.
+func (pw *PodmanWatcher) Client() interface{} {
+	pw.cmu.RLock()
+	defer pw.cmu.RUnlock()
+	if pw.podman == nil {
+		return nil
+	}
+	return pw.podman
+}
 
 // Close cleans up and release any engine client resources, if necessary.
 func (pw *PodmanWatcher) Close() {
 	if pw.podcache != nil {
 		pw.podcache.Stop()
 	}
-	if client, _ := bindings.GetClient(pw.podman); client != nil {
+	if pw.checkpoints != nil {
+		pw.checkpoints.Stop()
+	}
+	if pw.imagecache != nil {
+		pw.imagecache.Stop()
+	}
+	if pw.netcache != nil {
+		pw.netcache.Stop()
+	}
+	if pw.infracache != nil {
+		pw.infracache.Stop()
+	}
+	if pw.pidfds != nil {
+		pw.pidfds.close()
+	}
+	if pw.cgroups != nil {
+		pw.cgroups.close()
+	}
+	if client, _ := bindings.GetClient(pw.conn()); client != nil {
 		client.Client.CloseIdleConnections()
 	}
 }
 
 // List all the currently alive and kicking containers, but do not list any
-// containers without any processes.
+// containers without any processes – unless in full inventory mode, see
+// [WithFullInventory].
 func (pw *PodmanWatcher) List(svcctx context.Context) ([]*whalewatcher.Container, error) {
+	if err := pw.connected(svcctx); err != nil {
+		return nil, err
+	}
+	alives, err := pw.list(svcctx)
+	if err != nil {
+		return nil, err
+	}
+	if pw.resync != nil {
+		pw.resync.reset(alives)
+	}
+	if pw.eventslog == "" && pw.Polling(svcctx) {
+		pw.pollTracker().reset(alives)
+	}
+	return alives, nil
+}
+
+// list all the currently alive containers, or all containers in full inventory
+// mode, without updating any tracked containers.
+func (pw *PodmanWatcher) list(svcctx context.Context) ([]*whalewatcher.Container, error) {
 	ctx, release := pw.y(svcctx)
 	defer release()
 	// Scan the currently available containers and take only the alive into
 	// further consideration. This is a potentially lengthy operation, as we
 	// need to inspect each potential candidate individually due to the way the
 	// Docker daemon's API is designed.
-	containers, err := containers.List(ctx, nil)
+	listopts := &containers.ListOptions{}
+	if pw.selector != nil {
+		listopts.Filters = pw.selector.listFilters()
+	}
+	if pw.inventory {
+		listopts.All = &pw.inventory
+	}
+	containers, err := containers.List(ctx, listopts)
 	if err != nil {
-		return nil, err // list? what list??
+		return nil, util.Classify(err) // list? what list??
 	}
 	alives := make([]*whalewatcher.Container, 0, len(containers))
 	for _, container := range containers {
 		if alive, err := pw.Inspect(svcctx, container.ID); err == nil {
+			if pw.selector != nil && !pw.selector.matches(alive) {
+				continue
+			}
+			if pw.inframode != InfraShow && isInfra(alive) {
+				continue
+			}
 			alives = append(alives, alive)
 		} else {
 			// silently ignore missing containers that have gone since the list
@@ -208,15 +463,15 @@ func (pw *PodmanWatcher) Inspect(svcctx context.Context, nameorid string) (*whal
 
 	details, err := containers.Inspect(ctx, nameorid, nil)
 	if err != nil {
-		return nil, err
+		return nil, util.Classify(err)
 	}
-	if details.State == nil || details.State.Pid == 0 {
+	if details.State == nil || (details.State.Pid == 0 && !pw.inventory) {
 		return nil, engineclient.NewProcesslessContainerError(nameorid, "Podman")
 	}
 	cntr := &whalewatcher.Container{
 		ID:      details.ID,
 		Name:    details.Name,
-		Labels:  details.Config.Labels,
+		Labels:  copyLabels(details.Config.Labels),
 		PID:     details.State.Pid,
 		Project: details.Config.Labels[moby.ComposerProjectLabel],
 		Paused:  details.State.Paused,
@@ -224,6 +479,12 @@ func (pw *PodmanWatcher) Inspect(svcctx context.Context, nameorid string) (*whal
 	if cntr.Labels == nil {
 		cntr.Labels = map[string]string{}
 	}
+	if pw.inventory {
+		cntr.Labels[StateLabelName] = details.State.Status
+	}
+	if pw.parent != "" {
+		cntr.Labels[ParentContainerLabelName] = pw.parent
+	}
 	if details.HostConfig != nil && details.HostConfig.Privileged {
 		// Just the presence of the "magic" label is sufficient; the label's
 		// value doesn't matter.
@@ -235,9 +496,33 @@ func (pw *PodmanWatcher) Inspect(svcctx context.Context, nameorid string) (*whal
 	}
 	if details.IsInfra {
 		cntr.Labels[InfraLabelName] = "" // just mark the presence.
+	} else if details.Pod != "" && pw.inframode == InfraFold {
+		pw.foldInfra(ctx, cntr, details.Pod)
+	}
+	if pw.pidxlate != "" {
+		pw.translatePID(pw.pidxlate, cntr, details.State.StartedAt)
+	}
+	pw.annotateRestore(cntr, details)
+	if _, invisible := cntr.Labels[PIDInvisibleLabelName]; pw.earlyExits() && !invisible {
+		pw.pidfds.watch(details.ID, cntr.PID, details.State.CgroupPath)
+	}
+	if pw.cgroupEvents() && details.State.Pid != 0 {
+		pw.cgroups.watch(details.ID, details.State.CgroupPath)
+	}
+	if pw.execs != nil {
+		pw.updateExecSessions(ctx, details)
+	}
+	if pw.idmappings {
+		pw.annotateIDMappings(cntr, details)
+	}
+	if pw.images {
+		pw.annotateImage(ctx, cntr, details)
 	}
 	if pw.packer != nil {
-		pw.packer.Pack(cntr, details)
+		packSafely(keyedPacker{packer: pw.packer}, cntr, details, pw.onpackerpanic)
+	}
+	if pw.networks {
+		pw.packNetworkInfo(ctx, cntr, details)
 	}
 	return cntr, nil
 }
@@ -246,6 +531,17 @@ func (pw *PodmanWatcher) Inspect(svcctx context.Context, nameorid string) (*whal
 // in the lifecycle of containers getting born (=alive, as opposed to, say,
 // "conceived") and die.
 func (pw *PodmanWatcher) LifecycleEvents(svcctx context.Context) (<-chan engineclient.ContainerEvent, <-chan error) {
+	if err := pw.connected(svcctx); err != nil {
+		return failedEvents(err)
+	}
+	if pw.eventslog != "" {
+		return pw.tailLifecycleEvents(svcctx)
+	}
+	// Without any engine events we need to fall back to polling.
+	if pw.Polling(svcctx) {
+		pw.stopEarlyDetection()
+		return pw.pollLifecycleEvents(svcctx)
+	}
 	ctx, release := pw.y(svcctx)
 
 	cntreventstream := make(chan engineclient.ContainerEvent)
@@ -268,15 +564,15 @@ func (pw *PodmanWatcher) LifecycleEvents(svcctx context.Context) (<-chan enginec
 		// the correct "died" event status.
 		opts := system.EventsOptions{
 			Filters: map[string][]string{
-				"type": {"container"},
-				"status": {
-					"start",
-					"died",
-					"pause",
-					"unpause",
-				},
+				"type":   {"container"},
+				"status": pw.eventStatuses(),
 			},
 		}
+		if pw.selector != nil {
+			for key, values := range pw.selector.eventFilters() {
+				opts.Filters[key] = values
+			}
+		}
 		// Yet another P.o.'d.man API design horror: system.Events *blocks*, but
 		// also returns an error. This complicates things a lot as we need to
 		// kick off a separate go routine but also check for errors. Seriously,
@@ -309,16 +605,20 @@ func (pw *PodmanWatcher) LifecycleEvents(svcctx context.Context) (<-chan enginec
 				// seem to be any fd leakages.
 				//
 				// SCOTTY!!! BEAM ME UP FROM THIS PODMAN CODE BASE!!!
-				if conn, _ := bindings.GetClient(pw.podman); conn != nil {
+				if conn, _ := bindings.GetClient(pw.conn()); conn != nil {
 					conn.Client.CloseIdleConnections()
 				}
 				err = ctxerr
 			}
 			if err != nil {
-				cntrerrstream <- err
+				// Please note that we pass on context errors as is.
+				cntrerrstream <- classify(ctx, err)
 			}
 		}()
 		defer close(cancelch)
+		resyncctx, cancelresync := context.WithCancel(svcctx)
+		defer cancelresync()
+		resyncs := pw.resyncListings(resyncctx)
 		for {
 			select {
 			case <-apierr:
@@ -331,38 +631,13 @@ func (pw *PodmanWatcher) LifecycleEvents(svcctx context.Context) (<-chan enginec
 				// *snicker*
 				return // will tell system.Events to cancel.
 			case ev := <-evs:
-				// This is pretty much boilerplate, as even Podman's own events
-				// are Docker-compatible. Red Dan must still be fuming.
-				switch ev.Action {
-				case "start":
-					cntreventstream <- engineclient.ContainerEvent{
-						Type:    engineclient.ContainerStarted,
-						ID:      ev.Actor.ID,
-						Project: ev.Actor.Attributes[moby.ComposerProjectLabel],
-					}
-				case "died":
-					cntreventstream <- engineclient.ContainerEvent{
-						Type: engineclient.ContainerExited,
-						ID:   ev.Actor.ID,
-						// Please note that Podmen v3 and v4 lack support for
-						// container labels in "died" events; the default
-						// watcher implementation will work around this by
-						// looking up the project label if known.
-						Project: ev.Actor.Attributes[moby.ComposerProjectLabel],
-					}
-				case "pause":
-					cntreventstream <- engineclient.ContainerEvent{
-						Type:    engineclient.ContainerPaused,
-						ID:      ev.Actor.ID,
-						Project: ev.Actor.Attributes[moby.ComposerProjectLabel],
-					}
-				case "unpause":
-					cntreventstream <- engineclient.ContainerEvent{
-						Type:    engineclient.ContainerUnpaused,
-						ID:      ev.Actor.ID,
-						Project: ev.Actor.Attributes[moby.ComposerProjectLabel],
-					}
-				}
+				pw.forwardEvent(ctx, &ev, cntreventstream)
+			case id := <-pw.earlyExitEvents():
+				pw.forwardPidfdExit(id, cntreventstream)
+			case cntrev := <-pw.cgroupLifecycleEvents():
+				pw.forwardCgroupEvent(cntrev, cntreventstream)
+			case listing := <-resyncs:
+				pw.forwardResync(listing, cntreventstream)
 			}
 		}
 	}()
@@ -370,24 +645,81 @@ func (pw *PodmanWatcher) LifecycleEvents(svcctx context.Context) (<-chan enginec
 	return cntreventstream, cntrerrstream
 }
 
+// stopEarlyDetection stops any early exit detection and cgroup events
+// watching. Without Podman events, there is no one consuming the early
+// lifecycle events and no Podman events would ever confirm them.
+func (pw *PodmanWatcher) stopEarlyDetection() {
+	if pw.pidfds != nil {
+		pw.pidfds.close()
+	}
+	if pw.cgroups != nil {
+		pw.cgroups.close()
+	}
+}
+
+// annotateIDMappings adds the ID mappings annotation labels to the specified
+// container, if the container has its own user namespace. If the container's
+// ID mappings cannot be determined, then the container simply doesn't get
+// annotated. The ID mappings are read from the proc filesystem only if the
+// container's PID refers to the container's process as seen by us.
+func (pw *PodmanWatcher) annotateIDMappings(cntr *whalewatcher.Container, details *define.InspectContainerData) {
+	idmappings, _ := inspectIDMappings(details)
+	if idmappings == nil && pw.isLocal() && hasVisiblePID(cntr) {
+		idmappings, _ = procIDMappings("/proc", cntr.PID)
+	}
+	if idmappings == nil {
+		return
+	}
+	cntr.Labels[UIDMapLabelName] = formatIDMaps(idmappings.UIDs)
+	cntr.Labels[GIDMapLabelName] = formatIDMaps(idmappings.GIDs)
+}
+
+// isLocal returns true if the Podman engine is connected via a unix socket,
+// so that the container PIDs reported by the engine can be expected to refer
+// to processes on this host.
+func (pw *PodmanWatcher) isLocal() bool {
+	client, err := bindings.GetClient(pw.conn())
+	if err != nil {
+		return false
+	}
+	return client.URI.Scheme == "unix"
+}
+
 // Returns a podman connection context with the specified context's cancellation
 // and deadline mixed into it.
 func (pw *PodmanWatcher) y(ctx context.Context) (context.Context, context.CancelFunc) {
-	return wye.Mixin(pw.podman, ctx)
+	return wye.Mixin(pw.conn(), ctx)
 }
 
 // podName returns the name of a pod, given only its ID – as is the case with
 // the container details which always reference their pod (if any) by ID, never
-// by name.
+// by name. If the pod cannot be inspected, podName returns an empty name.
 func (pw *PodmanWatcher) podName(ctx context.Context, podid string) string {
+	podname, _ := pw.lookupPodName(ctx, podid)
+	return podname
+}
+
+// lookupPodName returns the name of a pod, given only its ID, or the
+// classified error in case the pod cannot be inspected.
+func (pw *PodmanWatcher) lookupPodName(ctx context.Context, podid string) (string, error) {
 	if podname := pw.podcache.Get(podid); podname != nil {
-		return podname.Value()
+		return podname.Value(), nil
 	}
 	poddetails, err := pods.Inspect(ctx, podid, &pods.InspectOptions{})
 	if err != nil {
 		// We don't do negative caching here.
-		return ""
+		return "", util.Classify(err)
 	}
 	pw.podcache.Set(podid, poddetails.Name, ttlcache.DefaultTTL)
-	return poddetails.Name
+	return poddetails.Name, nil
+}
+
+// classify returns the specified context's error if the context is done, so
+// that callers get back their own context errors as is. Otherwise, classify
+// returns the specified error classified, see [util.Classify].
+func classify(ctx context.Context, err error) error {
+	if ctxerr := ctx.Err(); ctxerr != nil {
+		return ctxerr
+	}
+	return util.Classify(err)
 }
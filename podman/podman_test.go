@@ -27,6 +27,7 @@ import (
 	"github.com/containers/podman/v4/pkg/rootless"
 	"github.com/containers/podman/v4/pkg/specgen"
 	"github.com/thediveo/sealwatcher/v2/test"
+	"github.com/thediveo/sealwatcher/v2/util"
 	"github.com/thediveo/whalewatcher"
 	"github.com/thediveo/whalewatcher/engineclient"
 	"github.com/thediveo/whalewatcher/engineclient/moby"
@@ -133,6 +134,42 @@ var _ = Describe("podman engineclient", Ordered, func() {
 		Expect(pw.Version(ctx)).To(MatchRegexp(`\d+.\d+.\d+`))
 	})
 
+	It("knows that the engine isn't rootless", func(ctx context.Context) {
+		Expect(pw.Rootless(ctx)).To(BeFalse())
+	})
+
+	It("doesn't annotate ID mappings of containers without user namespaces", func(ctx context.Context) {
+		pw := NewPodmanWatcher(podconn, WithIDMappings())
+		defer pw.Close()
+		Expect(pw.idmappings).To(BeTrue())
+		cntr := Successful(pw.Inspect(ctx, furiousFuruncle.Name))
+		Expect(cntr.Labels).NotTo(HaveKey(UIDMapLabelName))
+		Expect(cntr.Labels).NotTo(HaveKey(GIDMapLabelName))
+	})
+
+	It("annotates the image identity", func(ctx context.Context) {
+		pw := NewPodmanWatcher(podconn, WithImageAnnotations())
+		defer pw.Close()
+		cntr := Successful(pw.Inspect(ctx, furiousFuruncle.Name))
+		Expect(cntr.Labels).To(And(
+			HaveKeyWithValue(ImageNameLabelName, ContainSubstring("busybox")),
+			HaveKeyWithValue(ImageIDLabelName, Not(BeEmpty())),
+			HaveKeyWithValue(ImageDigestLabelName, ContainSubstring("@sha256:")),
+			HaveKeyWithValue(ImagePlatformLabelName, MatchRegexp(`^linux/`)),
+		))
+		Expect(pw.imagecache.Len()).To(Equal(1))
+	})
+
+	It("packs network information", func(ctx context.Context) {
+		pw := NewPodmanWatcher(podconn, WithNetworkInfo())
+		defer pw.Close()
+		cntr := Successful(pw.Inspect(ctx, furiousFuruncle.Name))
+		netinfo := ContainerNetworks(cntr)
+		Expect(netinfo).NotTo(BeNil())
+		Expect(netinfo.SharedFrom).To(BeEmpty())
+		Expect(netinfo.Networks).NotTo(BeEmpty())
+	})
+
 	It("sets a rucksack packer", func() {
 		p := packer{}
 		pw := NewPodmanWatcher(podconn, WithRucksackPacker(&p))
@@ -157,6 +194,20 @@ var _ = Describe("podman engineclient", Ordered, func() {
 		Expect(cntr.Rucksack).NotTo(BeNil())
 	})
 
+	It("packs stable Podman details", func(ctx context.Context) {
+		pw := NewPodmanWatcher(podconn, WithRucksackPacker(DetailsPacker{}), WithImageAnnotations())
+		defer pw.Close()
+		cntr := Successful(pw.Inspect(ctx, furiousFuruncle.Name))
+		details := ContainerDetails(cntr)
+		Expect(details).NotTo(BeNil())
+		Expect(details.Name).To(Equal(furiousFuruncle.Name))
+		Expect(details.State.Running).To(BeTrue())
+		Expect(details.State.PID).To(Equal(cntr.PID))
+		Expect(details.Pod).To(BeNil())
+		Expect(cntr.Labels).To(HaveKey(HavePrefix(PodmanAnnotation)))
+		Expect(details.Config.Labels).NotTo(HaveKey(HavePrefix(PodmanAnnotation)))
+	})
+
 	It("can't inspect a dead_dummy", func(ctx context.Context) {
 		ctx, cancel := context.WithCancel(ctx)
 		defer cancel()
@@ -164,6 +215,18 @@ var _ = Describe("podman engineclient", Ordered, func() {
 		Expect(pw.Inspect(ctx, deadDummy.Name)).Error().To(HaveOccurred())
 	})
 
+	It("inventories a dead_dummy", func(ctx context.Context) {
+		pw := NewPodmanWatcher(podconn, WithFullInventory())
+		defer pw.Close()
+		cntr := Successful(pw.Inspect(ctx, deadDummy.Name))
+		Expect(cntr.PID).To(BeZero())
+		Expect(cntr.Labels).To(HaveKeyWithValue(StateLabelName, "created"))
+		Expect(pw.List(ctx)).To(ContainElements(
+			And(HaveName(deadDummy.Name), HaveField("Labels", HaveKeyWithValue(StateLabelName, "created"))),
+			And(HaveName(furiousFuruncle.Name), HaveField("Labels", HaveKeyWithValue(StateLabelName, "running"))),
+		))
+	})
+
 	It("returns an error when trying to inspect a non-existing container", func(ctx context.Context) {
 		ctx, cancel := context.WithCancel(ctx)
 		defer cancel()
@@ -180,6 +243,20 @@ var _ = Describe("podman engineclient", Ordered, func() {
 		Expect(pw.List(ctx)).To(ContainElement(HaveName(furiousFuruncle.Name)))
 	})
 
+	It("lists only selected containers", func(ctx context.Context) {
+		pw := NewPodmanWatcher(podconn, WithSelector(Selector{
+			Labels: map[string]string{moby.ComposerProjectLabel: "testproject"},
+		}))
+		defer pw.Close()
+		Expect(pw.List(ctx)).To(ContainElement(HaveName(furiousFuruncle.Name)))
+
+		pw2 := NewPodmanWatcher(podconn, WithSelector(Selector{
+			Names: []string{"mad_mary"},
+		}))
+		defer pw2.Close()
+		Expect(pw2.List(ctx)).NotTo(ContainElement(HaveName(furiousFuruncle.Name)))
+	})
+
 	It("watches containers come and go", func(ctx context.Context) {
 		ctx, cancel := context.WithCancel(ctx)
 
@@ -235,6 +312,10 @@ var _ = Describe("podman engineclient", Ordered, func() {
 		Expect(pw.podName(podconn, "---podname-not-for-sale---")).To(BeEmpty())
 	})
 
+	It("reports non-existing pod IDs", func() {
+		Expect(pw.lookupPodName(podconn, "---podname-not-for-sale---")).Error().To(MatchError(util.ErrNoSuchPod))
+	})
+
 	It("determines pod names of containers", func(ctx context.Context) {
 		const podname = "dizzy_lizzy"
 
@@ -279,6 +360,54 @@ var _ = Describe("podman engineclient", Ordered, func() {
 			))
 	})
 
+	It("hides and folds infra containers", func(ctx context.Context) {
+		const podname = "dizzy_lizzy"
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		By("creating a pod")
+		pod := Successful(pods.CreatePodFromSpec(podconn, &entities.PodSpec{
+			PodSpecGen: specgen.PodSpecGenerator{
+				PodBasicConfig: specgen.PodBasicConfig{
+					Name: podname,
+				},
+			},
+		}))
+		defer func() {
+			force := true
+			pods.Remove(podconn, podname, &pods.RemoveOptions{Force: &force})
+		}()
+
+		By("creating a container in the pod")
+		id := test.NewContainer(podconn, madMay, test.OfPod(podname))
+
+		By("hiding the infra container")
+		hidingpw := NewPodmanWatcher(podconn, WithInfraMode(InfraHide))
+		defer hidingpw.Close()
+		Eventually(func() []*whalewatcher.Container {
+			cntrs, _ := hidingpw.List(ctx)
+			return cntrs
+		}).WithTimeout(5 * time.Second).Should(And(
+			ContainElement(HaveID(id)),
+			Not(ContainElement(HaveField("Labels", HaveKey(InfraLabelName)))),
+		))
+
+		By("folding the infra container")
+		foldingpw := NewPodmanWatcher(podconn, WithInfraMode(InfraFold))
+		defer foldingpw.Close()
+		cntrs := Successful(foldingpw.List(ctx))
+		Expect(cntrs).NotTo(ContainElement(HaveField("Labels", HaveKey(InfraLabelName))))
+		Expect(cntrs).To(ContainElement(And(
+			HaveID(id),
+			HaveField("Labels", And(
+				HaveKeyWithValue(PodIDName, pod.Id),
+				HaveKeyWithValue(InfraIDLabelName, Not(BeEmpty())),
+				HaveKeyWithValue(InfraPIDLabelName, MatchRegexp(`^\d+$`)),
+			)),
+		)))
+	})
+
 	It("queries the podman version information", func(ctx context.Context) {
 		Expect(pw.Try(ctx)).To(Succeed())
 		Expect(pw.Version(ctx)).NotTo(BeEmpty())
@@ -0,0 +1,113 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"context"
+	"time"
+
+	"github.com/thediveo/whalewatcher"
+	"github.com/thediveo/whalewatcher/engineclient"
+)
+
+// DefaultPollInterval is the default interval for polling the container list
+// when the Podman engine doesn't emit any events.
+const DefaultPollInterval = 5 * time.Second
+
+// WithPollInterval sets the interval for polling the container list when the
+// Podman engine has been configured with events_logger = "none" and thus
+// doesn't emit any events. Zero or negative intervals are ignored and the
+// [DefaultPollInterval] is used instead.
+func WithPollInterval(interval time.Duration) NewOption {
+	return func(pw *PodmanWatcher) {
+		if interval <= 0 {
+			return
+		}
+		pw.pollinterval = interval
+	}
+}
+
+// Polling returns true if the Podman engine doesn't emit any events, so that
+// lifecycle events are instead derived from polling the container list.
+func (pw *PodmanWatcher) Polling(svcctx context.Context) bool {
+	info, err := pw.engineInfo(svcctx)
+	return err == nil && info.Host != nil && info.Host.EventLogger == "none"
+}
+
+// pollLifecycleEvents periodically lists the containers and derives
+// lifecycle events from the differences between successive container lists,
+// instead of relying on engine events. The initial container list is the one
+// returned by List.
+func (pw *PodmanWatcher) pollLifecycleEvents(svcctx context.Context) (<-chan engineclient.ContainerEvent, <-chan error) {
+	return pollEvents(svcctx, pw.pollTracker(), pw.list)
+}
+
+// pollTracker returns the tracker for polling the container list, allocating
+// it on first use. This way, engines emitting events don't pay for keeping
+// track of the containers on each List.
+func (pw *PodmanWatcher) pollTracker() *tracker {
+	pw.pmu.Lock()
+	defer pw.pmu.Unlock()
+	if pw.poll == nil {
+		interval := pw.pollinterval
+		if interval <= 0 {
+			interval = DefaultPollInterval
+		}
+		pw.poll = newTracker(interval)
+	}
+	return pw.poll
+}
+
+// pollEvents periodically calls the specified list function at the tracker's
+// interval and derives lifecycle events from the differences to the known
+// containers. Polling stops with an error when the context gets cancelled or
+// listing the containers fails.
+func pollEvents(
+	svcctx context.Context,
+	t *tracker,
+	list func(context.Context) ([]*whalewatcher.Container, error),
+) (<-chan engineclient.ContainerEvent, <-chan error) {
+	cntreventstream := make(chan engineclient.ContainerEvent)
+	cntrerrstream := make(chan error, 1)
+
+	go func() {
+		defer close(cntrerrstream)
+		ticker := time.NewTicker(t.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-svcctx.Done():
+				cntrerrstream <- svcctx.Err()
+				return
+			case <-ticker.C:
+				cntrs, err := list(svcctx)
+				if err != nil {
+					cntrerrstream <- classify(svcctx, err)
+					return
+				}
+				for _, cntrev := range t.diff(cntrs) {
+					select {
+					case cntreventstream <- cntrev:
+					case <-svcctx.Done():
+						cntrerrstream <- svcctx.Err()
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	return cntreventstream, cntrerrstream
+}
@@ -0,0 +1,93 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/containers/podman/v4/libpod/define"
+	"github.com/thediveo/sealwatcher/v2/util"
+	"github.com/thediveo/whalewatcher"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("polling", func() {
+
+	It("sets the poll interval", func() {
+		pw := NewPodmanWatcher(context.Background())
+		defer pw.Close()
+		WithPollInterval(0)(pw)
+		Expect(pw.pollinterval).To(Equal(DefaultPollInterval))
+		WithPollInterval(time.Second)(pw)
+		Expect(pw.pollinterval).To(Equal(time.Second))
+	})
+
+	It("allocates the poll tracker only when needed", func() {
+		pw := NewPodmanWatcher(context.Background(), WithPollInterval(time.Second))
+		defer pw.Close()
+		Expect(pw.poll).To(BeNil())
+		t := pw.pollTracker()
+		Expect(t.interval).To(Equal(time.Second))
+		Expect(pw.pollTracker()).To(BeIdenticalTo(t))
+
+		Expect((&PodmanWatcher{}).pollTracker().interval).To(Equal(DefaultPollInterval))
+	})
+
+	DescribeTable("detecting the need to poll",
+		func(eventlogger string, expected bool) {
+			pw := &PodmanWatcher{info: &define.Info{
+				Host: &define.HostInfo{EventLogger: eventlogger},
+			}}
+			Expect(pw.Polling(context.Background())).To(Equal(expected))
+		},
+		Entry(nil, "none", true),
+		Entry(nil, "journald", false),
+		Entry(nil, "file", false),
+	)
+
+	It("stops polling when the context gets cancelled", func() {
+		pw := &PodmanWatcher{pollinterval: time.Hour}
+		ctx, cancel := context.WithCancel(context.Background())
+		evs, errs := pw.pollLifecycleEvents(ctx)
+		cancel()
+		Eventually(errs).Should(Receive(MatchError(context.Canceled)))
+		Consistently(evs).ShouldNot(Receive())
+		Eventually(errs).Should(BeClosed())
+	})
+
+	It("classifies listing errors", func(ctx context.Context) {
+		evs, errs := pollEvents(ctx, newTracker(10*time.Millisecond),
+			func(context.Context) ([]*whalewatcher.Container, error) {
+				return nil, &net.OpError{Op: "dial", Net: "unix", Err: syscall.ECONNREFUSED}
+			})
+		Eventually(errs).Should(Receive(MatchError(util.ErrServiceUnavailable)))
+		Expect(evs).NotTo(Receive())
+		Eventually(errs).Should(BeClosed())
+	})
+
+	It("passes on context errors as is", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		err := &net.OpError{Op: "dial", Net: "unix", Err: syscall.ECONNREFUSED}
+		Expect(classify(ctx, err)).To(MatchError(util.ErrServiceUnavailable))
+		cancel()
+		Expect(classify(ctx, err)).To(BeIdenticalTo(context.Canceled))
+	})
+
+})
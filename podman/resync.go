@@ -0,0 +1,289 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/thediveo/whalewatcher"
+	"github.com/thediveo/whalewatcher/engineclient"
+)
+
+// ResyncStats are counters about the periodic resynchronization of the known
+// containers with the engine's container list, see [WithResync].
+type ResyncStats struct {
+	Resyncs  uint64 // number of resynchronizations run.
+	Failures uint64 // number of resynchronizations failing to list containers.
+	Repairs  uint64 // number of resynchronizations that found and repaired drift.
+	Started  uint64 // number of synthetic ContainerStarted events.
+	Exited   uint64 // number of synthetic ContainerExited events.
+	Paused   uint64 // number of synthetic ContainerPaused events.
+	Unpaused uint64 // number of synthetic ContainerUnpaused events.
+}
+
+// WithResync periodically re-lists the containers at the specified interval
+// and compares the result with the containers known from the initial List and
+// the subsequent lifecycle events. Any drift, such as caused by Podman's
+// events backend dropping events, is then repaired by emitting synthetic
+// lifecycle events. Use [PodmanWatcher.ResyncStats] to query how often drift
+// was repaired. A zero or negative interval disables resynchronization.
+func WithResync(interval time.Duration) NewOption {
+	return func(pw *PodmanWatcher) {
+		if interval <= 0 {
+			pw.resync = nil
+			return
+		}
+		pw.resync = newTracker(interval)
+	}
+}
+
+// ResyncStats returns the current resynchronization counters. If
+// resynchronization hasn't been enabled using [WithResync], all counters are
+// zero.
+func (pw *PodmanWatcher) ResyncStats() ResyncStats {
+	if pw.resync == nil {
+		return ResyncStats{}
+	}
+	pw.resync.mu.Lock()
+	defer pw.resync.mu.Unlock()
+	return pw.resync.stats
+}
+
+// resyncListing is a container listing taken for resynchronization, or the
+// error in case listing failed.
+type resyncListing struct {
+	cntrs []*whalewatcher.Container
+	err   error
+}
+
+// resyncListings lists the containers in the background at the
+// resynchronization interval, returning a channel of the listings to be
+// passed to forwardResync in the lifecycle event loop. Listing in a separate
+// go routine avoids blocking the delivery of engine events while listing the
+// containers, whereas diffing in the event loop ensures that no lifecycle
+// events get passed on between diffing and delivering the resulting synthetic
+// events. When resynchronization hasn't been enabled, the channel is nil and
+// thus never delivers. The background go routine terminates when the specified
+// context is done.
+func (pw *PodmanWatcher) resyncListings(ctx context.Context) <-chan resyncListing {
+	if pw.resync == nil {
+		return nil
+	}
+	listings := make(chan resyncListing)
+	go func() {
+		ticker := time.NewTicker(pw.resync.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			pw.resync.begin()
+			cntrs, err := pw.list(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case listings <- resyncListing{cntrs: cntrs, err: err}:
+			}
+		}
+	}()
+	return listings
+}
+
+// forwardResync sends the synthetic lifecycle events needed to repair any
+// drift between the specified listing and the known containers down the
+// specified event stream. In case listing failed, no events are sent and the
+// known containers are kept, hoping for better luck next time. forwardResync
+// must be called from the lifecycle event loop, so that diffing and delivering
+// is atomic with respect to the lifecycle events passed on.
+func (pw *PodmanWatcher) forwardResync(listing resyncListing, cntreventstream chan<- engineclient.ContainerEvent) {
+	if listing.err != nil {
+		pw.resync.failed()
+		return
+	}
+	for _, cntrev := range pw.resync.diff(listing.cntrs) {
+		cntreventstream <- cntrev
+	}
+}
+
+// tracker keeps track of the containers known to the watcher, based on
+// container listings and the lifecycle events passed on to the watcher.
+type tracker struct {
+	interval time.Duration
+
+	mu    sync.Mutex
+	known map[string]trackedContainer // container ID -> tracked container
+	dirty map[string]struct{}         // containers observed since listing began, if in progress.
+	stats ResyncStats
+}
+
+// trackedContainer is the tracked state of a known container.
+type trackedContainer struct {
+	project string
+	paused  bool
+	state   string // only in full inventory mode; empty if unknown.
+}
+
+// newTracker returns a new container tracker, resynchronizing at the specified
+// interval.
+func newTracker(interval time.Duration) *tracker {
+	return &tracker{
+		interval: interval,
+		known:    map[string]trackedContainer{},
+	}
+}
+
+// reset the known containers to the specified containers.
+func (t *tracker) reset(cntrs []*whalewatcher.Container) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.known = trackedContainers(cntrs)
+}
+
+// begin a resynchronization, noting the containers observed from now on until
+// the listing gets diffed. As the container list might already be stale for
+// these containers, diff then leaves them alone.
+func (t *tracker) begin() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.dirty = map[string]struct{}{}
+}
+
+// observe updates the known containers with the specified lifecycle event
+// that is about to be passed on to the watcher.
+func (t *tracker) observe(ev engineclient.ContainerEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.dirty != nil {
+		t.dirty[ev.ID] = struct{}{}
+	}
+	switch ev.Type {
+	case engineclient.ContainerStarted:
+		// In full inventory mode, a ContainerStarted event might just be a
+		// refresh, so we don't know the container's new state, but the
+		// watcher will pick it up by inspecting the container.
+		t.known[ev.ID] = trackedContainer{project: ev.Project}
+	case engineclient.ContainerExited:
+		delete(t.known, ev.ID)
+	case engineclient.ContainerPaused, engineclient.ContainerUnpaused:
+		if cntr, ok := t.known[ev.ID]; ok {
+			cntr.paused = ev.Type == engineclient.ContainerPaused
+			t.known[ev.ID] = cntr
+		}
+	}
+}
+
+// failed counts a failed resynchronization.
+func (t *tracker) failed() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.dirty = nil
+	t.stats.Resyncs++
+	t.stats.Failures++
+}
+
+// diff returns the lifecycle events required to get from the known containers
+// to the specified listed containers, which then become the known containers.
+// Containers that have gone are reported first, followed by new containers,
+// and finally changes in the pause state. Containers observed while listing
+// was in progress keep their known state.
+func (t *tracker) diff(cntrs []*whalewatcher.Container) []engineclient.ContainerEvent {
+	listed := trackedContainers(cntrs)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stats.Resyncs++
+	var exited, started, changed []engineclient.ContainerEvent
+	for id, cntr := range t.known {
+		if _, ok := t.dirty[id]; ok {
+			continue
+		}
+		if _, ok := listed[id]; !ok {
+			exited = append(exited, engineclient.ContainerEvent{
+				Type: engineclient.ContainerExited, ID: id, Project: cntr.project})
+		}
+	}
+	for id, cntr := range listed {
+		if _, ok := t.dirty[id]; ok {
+			continue
+		}
+		known, ok := t.known[id]
+		if !ok || (known.state != "" && cntr.state != known.state) {
+			started = append(started, engineclient.ContainerEvent{
+				Type: engineclient.ContainerStarted, ID: id, Project: cntr.project})
+			// A started (or refreshed) container gets inspected by the watcher,
+			// so its pause state gets picked up correctly.
+			continue
+		}
+		if cntr.paused != known.paused {
+			evtype := engineclient.ContainerUnpaused
+			if cntr.paused {
+				evtype = engineclient.ContainerPaused
+			}
+			changed = append(changed, engineclient.ContainerEvent{
+				Type: evtype, ID: id, Project: cntr.project})
+		}
+	}
+	for id := range t.dirty {
+		if known, ok := t.known[id]; ok {
+			listed[id] = known
+		} else {
+			delete(listed, id)
+		}
+	}
+	t.known = listed
+	t.dirty = nil
+	evs := append(append(sortedEvents(exited), sortedEvents(started)...), sortedEvents(changed)...)
+	if len(evs) == 0 {
+		return nil
+	}
+	t.stats.Repairs++
+	for _, ev := range evs {
+		switch ev.Type {
+		case engineclient.ContainerStarted:
+			t.stats.Started++
+		case engineclient.ContainerExited:
+			t.stats.Exited++
+		case engineclient.ContainerPaused:
+			t.stats.Paused++
+		case engineclient.ContainerUnpaused:
+			t.stats.Unpaused++
+		}
+	}
+	return evs
+}
+
+// trackedContainers returns the tracked states of the specified containers,
+// indexed by container ID.
+func trackedContainers(cntrs []*whalewatcher.Container) map[string]trackedContainer {
+	tracked := make(map[string]trackedContainer, len(cntrs))
+	for _, cntr := range cntrs {
+		tracked[cntr.ID] = trackedContainer{
+			project: cntr.Project,
+			paused:  cntr.Paused,
+			state:   cntr.Labels[StateLabelName],
+		}
+	}
+	return tracked
+}
+
+// sortedEvents returns the specified events sorted by container ID.
+func sortedEvents(evs []engineclient.ContainerEvent) []engineclient.ContainerEvent {
+	sort.Slice(evs, func(i, j int) bool { return evs[i].ID < evs[j].ID })
+	return evs
+}
@@ -0,0 +1,161 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"context"
+	"time"
+
+	"github.com/thediveo/whalewatcher"
+	"github.com/thediveo/whalewatcher/engineclient"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("resynchronization", func() {
+
+	It("enables resynchronization only for positive intervals", func() {
+		pw := &PodmanWatcher{}
+		WithResync(0)(pw)
+		Expect(pw.resync).To(BeNil())
+		Expect(pw.ResyncStats()).To(BeZero())
+		WithResync(time.Minute)(pw)
+		Expect(pw.resync).NotTo(BeNil())
+		Expect(pw.resync.interval).To(Equal(time.Minute))
+	})
+
+	It("repairs drift", func() {
+		t := newTracker(time.Minute)
+		t.reset([]*whalewatcher.Container{
+			{ID: "1", Project: "foo"},
+			{ID: "2"},
+			{ID: "3"},
+		})
+		t.observe(engineclient.ContainerEvent{Type: engineclient.ContainerPaused, ID: "3"})
+		t.observe(engineclient.ContainerEvent{Type: engineclient.ContainerStarted, ID: "4"})
+
+		Expect(t.diff([]*whalewatcher.Container{
+			{ID: "2", Paused: true},
+			{ID: "3", Paused: true},
+			{ID: "4"},
+			{ID: "6", Project: "bar"},
+			{ID: "5"},
+		})).To(HaveExactElements(
+			engineclient.ContainerEvent{Type: engineclient.ContainerExited, ID: "1", Project: "foo"},
+			engineclient.ContainerEvent{Type: engineclient.ContainerStarted, ID: "5"},
+			engineclient.ContainerEvent{Type: engineclient.ContainerStarted, ID: "6", Project: "bar"},
+			engineclient.ContainerEvent{Type: engineclient.ContainerPaused, ID: "2"},
+		))
+		Expect(t.stats).To(Equal(ResyncStats{
+			Resyncs: 1, Repairs: 1, Started: 2, Exited: 1, Paused: 1}))
+
+		Expect(t.diff([]*whalewatcher.Container{
+			{ID: "2"},
+			{ID: "3", Paused: true},
+			{ID: "4"},
+			{ID: "5"},
+			{ID: "6", Project: "bar"},
+		})).To(HaveExactElements(
+			engineclient.ContainerEvent{Type: engineclient.ContainerUnpaused, ID: "2"},
+		))
+		Expect(t.diff([]*whalewatcher.Container{
+			{ID: "2"},
+			{ID: "3", Paused: true},
+			{ID: "4"},
+			{ID: "5"},
+			{ID: "6", Project: "bar"},
+		})).To(BeEmpty())
+		t.failed()
+		Expect(t.stats).To(Equal(ResyncStats{
+			Resyncs: 4, Failures: 1, Repairs: 2, Started: 2, Exited: 1, Paused: 1, Unpaused: 1}))
+	})
+
+	It("refreshes containers with changed states", func() {
+		t := newTracker(time.Minute)
+		t.reset([]*whalewatcher.Container{
+			{ID: "1", Labels: map[string]string{StateLabelName: "created"}},
+		})
+		Expect(t.diff([]*whalewatcher.Container{
+			{ID: "1", Labels: map[string]string{StateLabelName: "running"}},
+		})).To(HaveExactElements(
+			engineclient.ContainerEvent{Type: engineclient.ContainerStarted, ID: "1"},
+		))
+		t.observe(engineclient.ContainerEvent{Type: engineclient.ContainerStarted, ID: "1"})
+		Expect(t.diff([]*whalewatcher.Container{
+			{ID: "1", Labels: map[string]string{StateLabelName: "exited"}},
+		})).To(BeEmpty())
+	})
+
+	It("leaves containers alone that changed while listing", func() {
+		t := newTracker(time.Minute)
+		t.reset([]*whalewatcher.Container{
+			{ID: "1"},
+			{ID: "2"},
+		})
+		t.begin()
+		// container 1 dies and container 3 starts while listing...
+		t.observe(engineclient.ContainerEvent{Type: engineclient.ContainerExited, ID: "1"})
+		t.observe(engineclient.ContainerEvent{Type: engineclient.ContainerStarted, ID: "3"})
+		Expect(t.diff([]*whalewatcher.Container{
+			{ID: "1"},
+			{ID: "4"},
+		})).To(HaveExactElements(
+			engineclient.ContainerEvent{Type: engineclient.ContainerExited, ID: "2"},
+			engineclient.ContainerEvent{Type: engineclient.ContainerStarted, ID: "4"},
+		))
+		Expect(t.known).To(HaveLen(2))
+		Expect(t.known).To(HaveKey("3"))
+		Expect(t.known).To(HaveKey("4"))
+		Expect(t.dirty).To(BeNil())
+
+		t.begin()
+		t.failed()
+		Expect(t.dirty).To(BeNil())
+	})
+
+	It("doesn't pass on stale listings after interleaved lifecycle events", func() {
+		pw := NewPodmanWatcher(context.Background(), WithResync(time.Minute))
+		defer pw.Close()
+		pw.resync.reset([]*whalewatcher.Container{
+			{ID: "1"},
+		})
+		cntrevs := make(chan engineclient.ContainerEvent, 10)
+
+		// container 1 dies after the listing was taken, but before the
+		// listing gets diffed and the synthetic events delivered...
+		pw.resync.begin()
+		listing := resyncListing{cntrs: []*whalewatcher.Container{{ID: "1"}, {ID: "2"}}}
+		pw.forwardEarly(engineclient.ContainerEvent{Type: engineclient.ContainerExited, ID: "1"}, cntrevs)
+		pw.forwardResync(listing, cntrevs)
+		Expect(cntrevs).To(HaveLen(2))
+		Expect(<-cntrevs).To(Equal(engineclient.ContainerEvent{Type: engineclient.ContainerExited, ID: "1"}))
+		Expect(<-cntrevs).To(Equal(engineclient.ContainerEvent{Type: engineclient.ContainerStarted, ID: "2"}))
+		Expect(pw.resync.known).NotTo(HaveKey("1"))
+
+		pw.resync.begin()
+		pw.forwardResync(resyncListing{err: context.Canceled}, cntrevs)
+		Expect(cntrevs).To(BeEmpty())
+		Expect(pw.ResyncStats()).To(Equal(ResyncStats{
+			Resyncs: 2, Failures: 1, Repairs: 1, Started: 1}))
+	})
+
+	It("doesn't resynchronize when disabled", func() {
+		pw := NewPodmanWatcher(context.Background())
+		defer pw.Close()
+		Expect(pw.resyncListings(context.Background())).To(BeNil())
+	})
+
+})
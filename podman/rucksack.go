@@ -0,0 +1,58 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"github.com/thediveo/whalewatcher"
+)
+
+// MultiRucksack is a [whalewatcher.Container] Rucksack that carries multiple
+// items of typed container information, each one under its own key. Use
+// [PackRucksack] to add items and [UnpackRucksack] for typed lookups.
+type MultiRucksack map[string]interface{}
+
+// PackRucksack packs the specified item into the container's Rucksack under
+// the specified key, turning the Rucksack into a [MultiRucksack] as necessary.
+// If the Rucksack already contains something else than a MultiRucksack, such
+// as when an application-specific packer put its own information into it,
+// then these existing contents are kept under the empty key "".
+func PackRucksack(cntr *whalewatcher.Container, key string, item interface{}) {
+	switch rucksack := cntr.Rucksack.(type) {
+	case MultiRucksack:
+		rucksack[key] = item
+	case nil:
+		cntr.Rucksack = MultiRucksack{key: item}
+	default:
+		cntr.Rucksack = MultiRucksack{"": rucksack, key: item}
+	}
+}
+
+// UnpackRucksack returns the item of type T packed under the specified key
+// into the container's [MultiRucksack], together with true. If the container's
+// Rucksack isn't a MultiRucksack, or there is no item under the specified key,
+// or the item isn't of type T, then the zero value of T and false are
+// returned instead.
+func UnpackRucksack[T any](cntr *whalewatcher.Container, key string) (T, bool) {
+	var zero T
+	rucksack, ok := cntr.Rucksack.(MultiRucksack)
+	if !ok {
+		return zero, false
+	}
+	item, ok := rucksack[key].(T)
+	if !ok {
+		return zero, false
+	}
+	return item, true
+}
@@ -0,0 +1,61 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"github.com/thediveo/whalewatcher"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("multi rucksacks", func() {
+
+	It("packs into an empty rucksack", func() {
+		cntr := &whalewatcher.Container{}
+		PackRucksack(cntr, "foo", 42)
+		Expect(cntr.Rucksack).To(Equal(MultiRucksack{"foo": 42}))
+		PackRucksack(cntr, "bar", "baz")
+		Expect(cntr.Rucksack).To(Equal(MultiRucksack{"foo": 42, "bar": "baz"}))
+	})
+
+	It("keeps existing rucksack contents", func() {
+		cntr := &whalewatcher.Container{Rucksack: "foobar"}
+		PackRucksack(cntr, "foo", 42)
+		Expect(cntr.Rucksack).To(Equal(MultiRucksack{"": "foobar", "foo": 42}))
+	})
+
+	It("unpacks typed items", func() {
+		cntr := &whalewatcher.Container{}
+		_, ok := UnpackRucksack[int](cntr, "foo")
+		Expect(ok).To(BeFalse())
+		cntr.Rucksack = "foobar"
+		_, ok = UnpackRucksack[string](cntr, "")
+		Expect(ok).To(BeFalse())
+
+		PackRucksack(cntr, "foo", 42)
+		i, ok := UnpackRucksack[int](cntr, "foo")
+		Expect(ok).To(BeTrue())
+		Expect(i).To(Equal(42))
+		s, ok := UnpackRucksack[string](cntr, "")
+		Expect(ok).To(BeTrue())
+		Expect(s).To(Equal("foobar"))
+		_, ok = UnpackRucksack[string](cntr, "foo")
+		Expect(ok).To(BeFalse())
+		_, ok = UnpackRucksack[int](cntr, "bar")
+		Expect(ok).To(BeFalse())
+	})
+
+})
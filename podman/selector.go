@@ -0,0 +1,192 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/containers/podman/v4/libpod/define"
+	"github.com/thediveo/whalewatcher"
+)
+
+// Selector selects the subset of containers to watch, based on container
+// labels, names, and pods. Only containers matching all non-empty selector
+// criteria are watched.
+type Selector struct {
+	// Labels that a container must have, all of them. An empty label value
+	// only requires the label to be present, regardless of its value.
+	Labels map[string]string
+	// Names of containers, of which a container must have one.
+	Names []string
+	// Pods, either by name or ID, of which a container must belong to one.
+	Pods []string
+}
+
+// WithSelector limits watching to only those containers matching the
+// specified selector. As far as possible, the selection is done by the Podman
+// engine, using container list and event filters; otherwise, the selection
+// happens client-side.
+//
+// Please note that only container “start” events are subject to the full
+// selection, as Podman doesn't include container labels in “died” events.
+// Lifecycle events of containers that haven't been selected in the first place
+// thus might still be reported in case of “died”, “pause”, and “unpause”
+// events; the whalewatcher watcher then ignores these events for unknown
+// containers.
+func WithSelector(sel Selector) NewOption {
+	return func(pw *PodmanWatcher) {
+		pw.selector = &sel
+	}
+}
+
+// listFilters returns the libpod container list filters for this selector.
+func (s *Selector) listFilters() map[string][]string {
+	filters := map[string][]string{}
+	if len(s.Labels) > 0 {
+		labels := make([]string, 0, len(s.Labels))
+		for key, value := range s.Labels {
+			if value == "" {
+				labels = append(labels, key)
+				continue
+			}
+			labels = append(labels, key+"="+value)
+		}
+		sort.Strings(labels)
+		filters["label"] = labels
+	}
+	if len(s.Names) > 0 {
+		// libpod matches container names using regular expressions, so we
+		// need to anchor and quote the names.
+		names := make([]string, 0, len(s.Names))
+		for _, name := range s.Names {
+			names = append(names, "^"+regexp.QuoteMeta(name)+"$")
+		}
+		filters["name"] = names
+	}
+	if len(s.Pods) > 0 {
+		filters["pod"] = s.Pods
+	}
+	return filters
+}
+
+// eventFilters returns the libpod event filters for this selector. As libpod
+// doesn't filter container events by pod and because “died” events lack
+// labels, only container names can be pushed down as event filters.
+func (s *Selector) eventFilters() map[string][]string {
+	filters := map[string][]string{}
+	if len(s.Names) > 0 {
+		filters["container"] = s.Names
+	}
+	return filters
+}
+
+// matches returns true if the specified container matches this selector. The
+// container must have been inspected (and thus annotated) beforehand.
+func (s *Selector) matches(cntr *whalewatcher.Container) bool {
+	if !s.matchesLabels(cntr.Labels) {
+		return false
+	}
+	if len(s.Names) > 0 && !contains(s.Names, cntr.Name) {
+		return false
+	}
+	return s.matchesPod(cntr.Labels[PodIDName], cntr.Labels[PodLabelName])
+}
+
+// matchesEvent returns true if the container referenced by the specified
+// (start) event attributes matches this selector. For pod selectors, it might
+// be necessary to look up the pod name.
+func (s *Selector) matchesEvent(ctx context.Context, pw *PodmanWatcher, attrs map[string]string) bool {
+	if !s.matchesLabels(attrs) {
+		return false
+	}
+	if len(s.Names) > 0 && !contains(s.Names, attrs["name"]) {
+		return false
+	}
+	if len(s.Pods) == 0 {
+		return true
+	}
+	podid := attrs["podId"]
+	if podid == "" {
+		return false
+	}
+	return s.matchesPod(podid, pw.podName(ctx, podid))
+}
+
+// matchesDetails returns true if the container with the specified (raw)
+// inspection details matches this selector. In contrast to [Selector.matches],
+// the container doesn't need to have been inspected and annotated by the
+// watcher beforehand. For pod selectors, it might be necessary to look up the
+// pod name.
+func (s *Selector) matchesDetails(ctx context.Context, pw *PodmanWatcher, details *define.InspectContainerData) bool {
+	var labels map[string]string
+	if details.Config != nil {
+		labels = details.Config.Labels
+	}
+	if !s.matchesLabels(labels) {
+		return false
+	}
+	if len(s.Names) > 0 && !contains(s.Names, details.Name) {
+		return false
+	}
+	if len(s.Pods) == 0 {
+		return true
+	}
+	if details.Pod == "" {
+		return false
+	}
+	return s.matchesPod(details.Pod, pw.podName(ctx, details.Pod))
+}
+
+// matchesLabels returns true if the specified labels satisfy the label
+// selection.
+func (s *Selector) matchesLabels(labels map[string]string) bool {
+	for key, value := range s.Labels {
+		v, ok := labels[key]
+		if !ok || (value != "" && v != value) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesPod returns true if a pod with the specified ID and name satisfies
+// the pod selection. Similar to Podman, pod IDs might be abbreviated.
+func (s *Selector) matchesPod(podid, podname string) bool {
+	if len(s.Pods) == 0 {
+		return true
+	}
+	if podid == "" {
+		return false
+	}
+	for _, pod := range s.Pods {
+		if pod != "" && (pod == podname || strings.HasPrefix(podid, pod)) {
+			return true
+		}
+	}
+	return false
+}
+
+// contains returns true if the specified slice of strings contains s.
+func contains(slice []string, s string) bool {
+	for _, el := range slice {
+		if el == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"context"
+
+	"github.com/containers/podman/v4/libpod/define"
+	"github.com/thediveo/whalewatcher"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("container selectors", func() {
+
+	It("generates list and event filters", func() {
+		sel := Selector{
+			Labels: map[string]string{"team": "observability", "canary": ""},
+			Names:  []string{"furious_furuncle", "mad.mary"},
+			Pods:   []string{"dizzy_lizzy"},
+		}
+		Expect(sel.listFilters()).To(Equal(map[string][]string{
+			"label": {"canary", "team=observability"},
+			"name":  {"^furious_furuncle$", "^mad\\.mary$"},
+			"pod":   {"dizzy_lizzy"},
+		}))
+		Expect(sel.eventFilters()).To(Equal(map[string][]string{
+			"container": {"furious_furuncle", "mad.mary"},
+		}))
+		Expect((&Selector{}).listFilters()).To(BeEmpty())
+		Expect((&Selector{}).eventFilters()).To(BeEmpty())
+	})
+
+	It("matches containers", func() {
+		cntr := &whalewatcher.Container{
+			Name: "furious_furuncle",
+			Labels: map[string]string{
+				"team":       "observability",
+				"canary":     "yes",
+				PodIDName:    "1234567890",
+				PodLabelName: "dizzy_lizzy",
+			},
+		}
+		Expect((&Selector{}).matches(cntr)).To(BeTrue())
+		Expect((&Selector{Labels: map[string]string{"team": "observability", "canary": ""}}).matches(cntr)).To(BeTrue())
+		Expect((&Selector{Labels: map[string]string{"team": "sre"}}).matches(cntr)).To(BeFalse())
+		Expect((&Selector{Labels: map[string]string{"foo": ""}}).matches(cntr)).To(BeFalse())
+		Expect((&Selector{Names: []string{"mad_mary", "furious_furuncle"}}).matches(cntr)).To(BeTrue())
+		Expect((&Selector{Names: []string{"mad_mary"}}).matches(cntr)).To(BeFalse())
+		Expect((&Selector{Pods: []string{"dizzy_lizzy"}}).matches(cntr)).To(BeTrue())
+		Expect((&Selector{Pods: []string{"123456"}}).matches(cntr)).To(BeTrue())
+		Expect((&Selector{Pods: []string{"foobar", ""}}).matches(cntr)).To(BeFalse())
+		Expect((&Selector{Pods: []string{"dizzy_lizzy"}}).matches(&whalewatcher.Container{})).To(BeFalse())
+	})
+
+	It("matches start event attributes", func() {
+		ctx := context.Background()
+		attrs := map[string]string{
+			"name":  "furious_furuncle",
+			"team":  "observability",
+			"podId": "",
+		}
+		Expect((&Selector{}).matchesEvent(ctx, nil, attrs)).To(BeTrue())
+		Expect((&Selector{Labels: map[string]string{"team": "observability"}}).matchesEvent(ctx, nil, attrs)).To(BeTrue())
+		Expect((&Selector{Labels: map[string]string{"team": "sre"}}).matchesEvent(ctx, nil, attrs)).To(BeFalse())
+		Expect((&Selector{Names: []string{"furious_furuncle"}}).matchesEvent(ctx, nil, attrs)).To(BeTrue())
+		Expect((&Selector{Names: []string{"mad_mary"}}).matchesEvent(ctx, nil, attrs)).To(BeFalse())
+		Expect((&Selector{Pods: []string{"dizzy_lizzy"}}).matchesEvent(ctx, nil, attrs)).To(BeFalse())
+	})
+
+	It("matches inspection details", func() {
+		ctx := context.Background()
+		details := &define.InspectContainerData{
+			Name: "furious_furuncle",
+			Config: &define.InspectContainerConfig{
+				Labels: map[string]string{"team": "observability"},
+			},
+		}
+		Expect((&Selector{}).matchesDetails(ctx, nil, details)).To(BeTrue())
+		Expect((&Selector{Labels: map[string]string{"team": "observability"}}).matchesDetails(ctx, nil, details)).To(BeTrue())
+		Expect((&Selector{Labels: map[string]string{"team": "sre"}}).matchesDetails(ctx, nil, details)).To(BeFalse())
+		Expect((&Selector{Names: []string{"furious_furuncle"}}).matchesDetails(ctx, nil, details)).To(BeTrue())
+		Expect((&Selector{Names: []string{"mad_mary"}}).matchesDetails(ctx, nil, details)).To(BeFalse())
+		Expect((&Selector{Pods: []string{"dizzy_lizzy"}}).matchesDetails(ctx, nil, details)).To(BeFalse())
+		Expect((&Selector{Labels: map[string]string{"team": ""}}).matchesDetails(
+			ctx, nil, &define.InspectContainerData{})).To(BeFalse())
+	})
+
+})
@@ -0,0 +1,157 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ServiceEndpoint is a Podman API service endpoint, as discovered from a
+// running "podman system service" process.
+type ServiceEndpoint struct {
+	PID int    // PID of the "podman system service" process.
+	UID int    // (real) UID the service runs as.
+	URI string // API URI the service listens on, such as "unix:///run/podman/podman.sock".
+}
+
+// serviceValueFlags are the (global and service) podman CLI flags that take a
+// value, so that a flag's value doesn't get mistaken for the service URI.
+var serviceValueFlags = map[string]bool{
+	"-t": true, "--time": true, "--cors": true,
+	"-c": true, "--connection": true, "--url": true, "--identity": true,
+	"--log-level": true, "--root": true, "--runroot": true, "--tmpdir": true,
+	"--cgroup-manager": true, "--conmon": true, "--events-backend": true,
+	"--hooks-dir": true, "--network-cmd-path": true, "--network-config-dir": true,
+	"--runtime": true, "--runtime-flag": true, "--storage-driver": true,
+	"--storage-opt": true, "--volumepath": true, "--module": true, "--ssh": true,
+	"--config": true, "--db-backend": true, "--imagestore": true,
+}
+
+// DiscoverServices scans /proc for running "podman system service" processes,
+// returning their API endpoints sorted by PID. When a service's command line
+// doesn't specify the URI to listen on, such as when the service has been
+// socket-activated, the default URI for the service's UID is assumed.
+//
+// The discovered URIs can be passed to sealwatcher.New, together with the PID
+// using [WithPID]. For services in other mount namespaces, such as in other
+// containers, use sealwatcher.NewForPID instead.
+func DiscoverServices() ([]ServiceEndpoint, error) {
+	return discoverServices("/proc")
+}
+
+func discoverServices(procroot string) ([]ServiceEndpoint, error) {
+	procs, err := scanProcs(procroot)
+	if err != nil {
+		return nil, err
+	}
+	endpoints := []ServiceEndpoint{}
+	for _, proc := range procs {
+		if proc.comm != "podman" {
+			continue
+		}
+		cmdline, err := os.ReadFile(filepath.Join(procroot, strconv.Itoa(proc.pid), "cmdline"))
+		if err != nil {
+			continue
+		}
+		uri, ok := parseServiceCmdline(cmdline)
+		if !ok {
+			continue
+		}
+		uid, ok := procUID(procroot, proc.pid)
+		if !ok {
+			continue
+		}
+		if uri == "" {
+			uri = defaultServiceURI(uid)
+		}
+		endpoints = append(endpoints, ServiceEndpoint{PID: proc.pid, UID: uid, URI: uri})
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].PID < endpoints[j].PID
+	})
+	return endpoints, nil
+}
+
+// parseServiceCmdline returns the URI from the specified "podman system
+// service" command line, as read from /proc/[PID]/cmdline. The URI is empty if
+// the command line doesn't specify it. parseServiceCmdline returns false if
+// the command line isn't a "podman system service" command line.
+func parseServiceCmdline(cmdline []byte) (string, bool) {
+	args := strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00")
+	if filepath.Base(args[0]) != "podman" {
+		return "", false
+	}
+	// Find the "system service" (sub) command, skipping any flags and their
+	// values, and then the URI argument, if any.
+	commands := []string{}
+	for idx := 1; idx < len(args); idx++ {
+		arg := args[idx]
+		if strings.HasPrefix(arg, "-") {
+			if flag, _, hasvalue := strings.Cut(arg, "="); !hasvalue && serviceValueFlags[flag] {
+				idx++
+			}
+			continue
+		}
+		commands = append(commands, arg)
+	}
+	if len(commands) < 2 || commands[0] != "system" || commands[1] != "service" {
+		return "", false
+	}
+	if len(commands) > 2 {
+		return commands[2], true
+	}
+	return "", true
+}
+
+// procUID returns the real UID of the specified process.
+func procUID(procroot string, pid int) (int, bool) {
+	f, err := os.Open(filepath.Join(procroot, strconv.Itoa(pid), "status"))
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "Uid:") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, "Uid:"))
+		if len(fields) == 0 {
+			return 0, false
+		}
+		uid, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return 0, false
+		}
+		return uid, true
+	}
+	return 0, false
+}
+
+// defaultServiceURI returns the default Podman API service URI for the
+// specified UID: the rootful socket for root, and the rootless socket inside
+// the user's runtime directory otherwise.
+func defaultServiceURI(uid int) string {
+	if uid == 0 {
+		return DefaultSocket
+	}
+	return "unix:///run/user/" + strconv.Itoa(uid) + "/podman/podman.sock"
+}
@@ -0,0 +1,78 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/thediveo/success"
+)
+
+// fakeService adds a podman process with the specified UID and command line
+// arguments to the fake procfs at procroot.
+func fakeService(procroot string, pid, uid int, args ...string) {
+	GinkgoHelper()
+	fakeProc(procroot, pid, 1, "podman", append([]string{"/usr/bin/podman"}, args...)...)
+	Expect(os.WriteFile(filepath.Join(procroot, strconv.Itoa(pid), "status"),
+		[]byte("Name:\tpodman\nUid:\t"+strconv.Itoa(uid)+"\t"+strconv.Itoa(uid)+"\t0\t0\n"), 0644)).To(Succeed())
+}
+
+var _ = Describe("podman service discovery", func() {
+
+	DescribeTable("parses service command lines",
+		func(cmdline []string, expectedURI string, expectedOK bool) {
+			uri, ok := parseServiceCmdline([]byte(strings.Join(cmdline, "\x00") + "\x00"))
+			Expect(ok).To(Equal(expectedOK))
+			Expect(uri).To(Equal(expectedURI))
+		},
+		Entry(nil, []string{"podman", "system", "service"}, "", true),
+		Entry(nil, []string{"/usr/bin/podman", "system", "service", "--time", "0", "unix:///tmp/p.sock"}, "unix:///tmp/p.sock", true),
+		Entry(nil, []string{"podman", "--log-level", "info", "system", "service", "-t=5", "tcp://localhost:8080"}, "tcp://localhost:8080", true),
+		Entry(nil, []string{"podman", "--root=/foo", "system", "service", "--cors", "*"}, "", true),
+		Entry(nil, []string{"podman", "system", "prune"}, "", false),
+		Entry(nil, []string{"podman", "run", "system", "service"}, "", false),
+		Entry(nil, []string{"podman"}, "", false),
+		Entry(nil, []string{"/usr/bin/conmon", "system", "service"}, "", false),
+	)
+
+	It("discovers services", func() {
+		procroot := GinkgoT().TempDir()
+		fakeProc(procroot, 1, 0, "systemd", "/sbin/init")
+		fakeService(procroot, 4242, 1000, "system", "service", "--time=0")
+		fakeService(procroot, 42, 0, "system", "service", "-t", "0", "unix:///run/podman/podman.sock")
+		fakeService(procroot, 666, 0, "ps")
+		fakeService(procroot, 777, 1001, "system", "service")
+		Expect(os.Remove(filepath.Join(procroot, "777", "status"))).To(Succeed())
+
+		Expect(Successful(discoverServices(procroot))).To(HaveExactElements(
+			ServiceEndpoint{PID: 42, UID: 0, URI: "unix:///run/podman/podman.sock"},
+			ServiceEndpoint{PID: 4242, UID: 1000, URI: "unix:///run/user/1000/podman/podman.sock"},
+		))
+
+		Expect(discoverServices(filepath.Join(procroot, "nada"))).Error().To(HaveOccurred())
+		Expect(DiscoverServices()).Error().NotTo(HaveOccurred())
+	})
+
+	It("returns default service URIs", func() {
+		Expect(defaultServiceURI(0)).To(Equal(DefaultSocket))
+		Expect(defaultServiceURI(1000)).To(Equal("unix:///run/user/1000/podman/podman.sock"))
+	})
+
+})
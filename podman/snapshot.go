@@ -0,0 +1,365 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/containers/podman/v4/libpod/define"
+	"github.com/thediveo/whalewatcher"
+	"github.com/thediveo/whalewatcher/engineclient/moby"
+	bolt "go.etcd.io/bbolt"
+
+	_ "github.com/mattn/go-sqlite3" // SQLite driver, as used by Podman itself.
+)
+
+// DefaultStorageRoot is the default storage root (“graph root”) of a rootful
+// Podman. Rootless Podman instead defaults to
+// $HOME/.local/share/containers/storage.
+const DefaultStorageRoot = "/var/lib/containers/storage"
+
+// Names of Podman's on-disk state files and the libpod BoltDB buckets and
+// keys needed to read container information.
+const (
+	boltStateDB       = "libpod/bolt_state.db"
+	sqliteStateDB     = "libpod/db.sql"
+	storageContainers = "*-containers/containers.json"
+	boltAllCtrsBucket = "all-ctrs"
+	boltCtrBucket     = "ctr"
+	boltPodBucket     = "pod"
+	boltConfigKey     = "config"
+	boltStateKey      = "state"
+)
+
+// Queries for the container configurations and states, as well as the pod
+// names, in the libpod SQLite state database.
+const (
+	sqliteContainersQuery = `SELECT ContainerConfig.ID, ContainerConfig.JSON, ContainerState.JSON
+		FROM ContainerConfig LEFT JOIN ContainerState ON ContainerConfig.ID = ContainerState.ID`
+	sqlitePodsQuery = `SELECT ID, Name FROM PodConfig`
+)
+
+// stateOpenTimeout is the maximum time to wait for Podman to release its lock
+// on the libpod state database.
+const stateOpenTimeout = time.Second
+
+// Snapshot returns the containers found in Podman's on-disk state at the
+// specified storage root, without needing a running Podman API service; for
+// instance, for forensic analysis or early during boot. If storageroot is
+// empty, [DefaultStorageRoot] is used. The containers get the same labels as
+// [PodmanWatcher.Inspect] would set in full inventory mode, see
+// [WithFullInventory]. However, the state recorded on disk might be stale,
+// such as after a system crash, so container PIDs are only informational.
+//
+// Snapshot reads the libpod state database in read-only mode, supporting both
+// the BoltDB and the SQLite state database. Without a libpod state database,
+// Snapshot falls back to containers/storage's containers.json, which only
+// tells container IDs, names, and images, but neither container labels nor
+// states. Such containers are labelled with the “unknown” state, see
+// [StateLabelName], as well as with their image name and ID, see
+// [ImageNameLabelName] and [ImageIDLabelName]. If there is no state at all,
+// Snapshot returns no containers.
+func Snapshot(storageroot string) ([]*whalewatcher.Container, error) {
+	if storageroot == "" {
+		storageroot = DefaultStorageRoot
+	}
+	dbpath := filepath.Join(storageroot, boltStateDB)
+	if _, err := os.Stat(dbpath); err == nil {
+		return boltSnapshot(dbpath)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	sqlpath := filepath.Join(storageroot, sqliteStateDB)
+	if _, err := os.Stat(sqlpath); err == nil {
+		return sqliteSnapshot(sqlpath)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return storageSnapshot(storageroot)
+}
+
+// libpodContainerConfig is the subset of libpod's container configuration as
+// stored in the libpod state database.
+type libpodContainerConfig struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Pod        string            `json:"pod,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Privileged bool              `json:"privileged"`
+	IsInfra    bool              `json:"pause"`
+}
+
+// libpodContainerState is the subset of libpod's container state as stored in
+// the libpod state database.
+type libpodContainerState struct {
+	State define.ContainerStatus `json:"state"`
+	PID   int                    `json:"pid,omitempty"`
+}
+
+// libpodPodConfig is the subset of libpod's pod configuration as stored in
+// the libpod state database.
+type libpodPodConfig struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// boltSnapshot returns the containers found in the libpod BoltDB state
+// database at the specified path, sorted by container name.
+func boltSnapshot(dbpath string) ([]*whalewatcher.Container, error) {
+	db, err := bolt.Open(dbpath, 0400, &bolt.Options{
+		ReadOnly: true,
+		Timeout:  stateOpenTimeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot open Podman state database %s: %w", dbpath, err)
+	}
+	defer db.Close()
+	var cntrs []*whalewatcher.Container
+	err = db.View(func(tx *bolt.Tx) error {
+		allctrs := tx.Bucket([]byte(boltAllCtrsBucket))
+		ctrs := tx.Bucket([]byte(boltCtrBucket))
+		if allctrs == nil || ctrs == nil {
+			return nil // no containers have ever been created.
+		}
+		podnames := boltPodNames(tx)
+		return allctrs.ForEach(func(id, _ []byte) error {
+			ctr := ctrs.Bucket(id)
+			if ctr == nil {
+				return nil
+			}
+			cntr, err := newLibpodContainer(
+				ctr.Get([]byte(boltConfigKey)), ctr.Get([]byte(boltStateKey)), podnames)
+			if err != nil {
+				return fmt.Errorf("invalid state of container %s: %w", string(id), err)
+			}
+			cntrs = append(cntrs, cntr)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortContainers(cntrs)
+	return cntrs, nil
+}
+
+// boltPodNames returns the names of all pods in the BoltDB state database,
+// indexed by pod ID.
+func boltPodNames(tx *bolt.Tx) map[string]string {
+	podnames := map[string]string{}
+	pods := tx.Bucket([]byte(boltPodBucket))
+	if pods == nil {
+		return podnames
+	}
+	_ = pods.ForEach(func(id, _ []byte) error {
+		pod := pods.Bucket(id)
+		if pod == nil {
+			return nil
+		}
+		var config libpodPodConfig
+		if json.Unmarshal(pod.Get([]byte(boltConfigKey)), &config) == nil {
+			podnames[string(id)] = config.Name
+		}
+		return nil
+	})
+	return podnames
+}
+
+// newLibpodContainer returns a container for the specified JSON container
+// configuration and state from the libpod state database, labelled as Inspect
+// would label it. The state is optional.
+func newLibpodContainer(configjson, statejson []byte, podnames map[string]string) (*whalewatcher.Container, error) {
+	var config libpodContainerConfig
+	if err := json.Unmarshal(configjson, &config); err != nil {
+		return nil, err
+	}
+	var state libpodContainerState
+	if len(statejson) != 0 {
+		if err := json.Unmarshal(statejson, &state); err != nil {
+			return nil, err
+		}
+	}
+	cntr := &whalewatcher.Container{
+		ID:      config.ID,
+		Name:    config.Name,
+		Labels:  config.Labels,
+		Project: config.Labels[moby.ComposerProjectLabel],
+		Paused:  state.State == define.ContainerStatePaused,
+	}
+	if state.State == define.ContainerStateRunning || state.State == define.ContainerStatePaused {
+		cntr.PID = state.PID
+	}
+	if cntr.Labels == nil {
+		cntr.Labels = map[string]string{}
+	}
+	cntr.Labels[StateLabelName] = state.State.String()
+	if config.Privileged {
+		cntr.Labels[moby.PrivilegedLabel] = ""
+	}
+	if config.Pod != "" {
+		cntr.Labels[PodIDName] = config.Pod
+		cntr.Labels[PodLabelName] = podnames[config.Pod]
+	}
+	if config.IsInfra {
+		cntr.Labels[InfraLabelName] = ""
+	}
+	return cntr, nil
+}
+
+// sqliteSnapshot returns the containers found in the libpod SQLite state
+// database at the specified path, sorted by container name.
+func sqliteSnapshot(dbpath string) ([]*whalewatcher.Container, error) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro&_busy_timeout=%d",
+		dbpath, stateOpenTimeout.Milliseconds()))
+	if err != nil {
+		return nil, fmt.Errorf("cannot open Podman state database %s: %w", dbpath, err)
+	}
+	defer db.Close()
+	podnames, err := sqlitePodNames(db)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read Podman state database %s: %w", dbpath, err)
+	}
+	rows, err := db.Query(sqliteContainersQuery)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read Podman state database %s: %w", dbpath, err)
+	}
+	defer rows.Close()
+	var cntrs []*whalewatcher.Container
+	for rows.Next() {
+		var id, configjson string
+		var statejson sql.NullString
+		if err := rows.Scan(&id, &configjson, &statejson); err != nil {
+			return nil, fmt.Errorf("cannot read Podman state database %s: %w", dbpath, err)
+		}
+		cntr, err := newLibpodContainer([]byte(configjson), []byte(statejson.String), podnames)
+		if err != nil {
+			return nil, fmt.Errorf("invalid state of container %s: %w", id, err)
+		}
+		cntrs = append(cntrs, cntr)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read Podman state database %s: %w", dbpath, err)
+	}
+	sortContainers(cntrs)
+	return cntrs, nil
+}
+
+// sqlitePodNames returns the names of all pods in the SQLite state database,
+// indexed by pod ID.
+func sqlitePodNames(db *sql.DB) (map[string]string, error) {
+	rows, err := db.Query(sqlitePodsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	podnames := map[string]string{}
+	for rows.Next() {
+		var id, name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		podnames[id] = name
+	}
+	return podnames, rows.Err()
+}
+
+// storageContainer is the subset of a container's information as stored by
+// containers/storage in its containers.json.
+type storageContainer struct {
+	ID       string   `json:"id"`
+	Names    []string `json:"names,omitempty"`
+	ImageID  string   `json:"image,omitempty"`
+	Metadata string   `json:"metadata,omitempty"`
+}
+
+// storageMetadata is the subset of the libpod-specific container metadata in
+// containers/storage's containers.json.
+type storageMetadata struct {
+	ImageName     string `json:"image-name"`
+	ImageID       string `json:"image-id"`
+	ContainerName string `json:"name"`
+}
+
+// storageSnapshot returns the containers found in containers/storage's
+// containers.json at the specified storage root, sorted by container name.
+func storageSnapshot(storageroot string) ([]*whalewatcher.Container, error) {
+	paths, err := filepath.Glob(filepath.Join(storageroot, storageContainers))
+	if err != nil {
+		return nil, err
+	}
+	var cntrs []*whalewatcher.Container
+	for _, path := range paths {
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var storagecntrs []storageContainer
+		if err := json.Unmarshal(contents, &storagecntrs); err != nil {
+			return nil, fmt.Errorf("invalid containers/storage state %s: %w", path, err)
+		}
+		for _, sc := range storagecntrs {
+			cntrs = append(cntrs, newStorageContainer(sc))
+		}
+	}
+	sortContainers(cntrs)
+	return cntrs, nil
+}
+
+// newStorageContainer returns a container for the specified containers/storage
+// container information. As containers/storage knows neither the container
+// labels nor the container state, the container is labelled with the
+// “unknown” state, as well as with its image name and ID, if known.
+func newStorageContainer(sc storageContainer) *whalewatcher.Container {
+	cntr := &whalewatcher.Container{
+		ID: sc.ID,
+		Labels: map[string]string{
+			StateLabelName: define.ContainerStateUnknown.String(),
+		},
+	}
+	var metadata storageMetadata
+	if sc.Metadata != "" && json.Unmarshal([]byte(sc.Metadata), &metadata) == nil {
+		cntr.Name = metadata.ContainerName
+	}
+	if cntr.Name == "" && len(sc.Names) > 0 {
+		cntr.Name = sc.Names[0]
+	}
+	if metadata.ImageName != "" {
+		cntr.Labels[ImageNameLabelName] = metadata.ImageName
+	}
+	if metadata.ImageID == "" {
+		metadata.ImageID = sc.ImageID
+	}
+	if metadata.ImageID != "" {
+		cntr.Labels[ImageIDLabelName] = metadata.ImageID
+	}
+	return cntr
+}
+
+// sortContainers sorts the specified containers by name and then ID.
+func sortContainers(cntrs []*whalewatcher.Container) {
+	sort.Slice(cntrs, func(i, j int) bool {
+		if cntrs[i].Name != cntrs[j].Name {
+			return cntrs[i].Name < cntrs[j].Name
+		}
+		return cntrs[i].ID < cntrs[j].ID
+	})
+}
@@ -0,0 +1,209 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/containers/podman/v4/libpod/define"
+	"github.com/thediveo/whalewatcher/engineclient/moby"
+	bolt "go.etcd.io/bbolt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/thediveo/success"
+)
+
+// writeBoltState writes a libpod BoltDB state fixture to the specified storage
+// root, with the specified containers and pods.
+func writeBoltState(storageroot string, cntrs map[string]libpodContainerConfig, states map[string]libpodContainerState, pods map[string]string) {
+	GinkgoHelper()
+	dbpath := filepath.Join(storageroot, boltStateDB)
+	Expect(os.MkdirAll(filepath.Dir(dbpath), 0700)).To(Succeed())
+	db := Successful(bolt.Open(dbpath, 0600, nil))
+	defer db.Close()
+	Expect(db.Update(func(tx *bolt.Tx) error {
+		allctrs := Successful(tx.CreateBucket([]byte(boltAllCtrsBucket)))
+		ctrs := Successful(tx.CreateBucket([]byte(boltCtrBucket)))
+		for id, config := range cntrs {
+			Expect(allctrs.Put([]byte(id), []byte(config.Name))).To(Succeed())
+			ctr := Successful(ctrs.CreateBucket([]byte(id)))
+			Expect(ctr.Put([]byte(boltConfigKey), Successful(json.Marshal(config)))).To(Succeed())
+			if state, ok := states[id]; ok {
+				Expect(ctr.Put([]byte(boltStateKey), Successful(json.Marshal(state)))).To(Succeed())
+			}
+		}
+		podsbkt := Successful(tx.CreateBucket([]byte(boltPodBucket)))
+		for id, name := range pods {
+			pod := Successful(podsbkt.CreateBucket([]byte(id)))
+			Expect(pod.Put([]byte(boltConfigKey),
+				Successful(json.Marshal(libpodPodConfig{ID: id, Name: name})))).To(Succeed())
+		}
+		return nil
+	})).To(Succeed())
+}
+
+var _ = Describe("offline snapshots", func() {
+
+	var storageroot string
+
+	BeforeEach(func() {
+		storageroot = GinkgoT().TempDir()
+	})
+
+	It("returns nothing for an empty storage root", func() {
+		Expect(Snapshot(storageroot)).To(BeEmpty())
+	})
+
+	It("reads containers from the libpod state database", func() {
+		writeBoltState(storageroot,
+			map[string]libpodContainerConfig{
+				"1234": {
+					ID:         "1234",
+					Name:       "foo",
+					Labels:     map[string]string{moby.ComposerProjectLabel: "project"},
+					Privileged: true,
+				},
+				"5678": {ID: "5678", Name: "bar", Pod: "abcd"},
+				"9abc": {ID: "9abc", Name: "abcd-infra", Pod: "abcd", IsInfra: true},
+			},
+			map[string]libpodContainerState{
+				"1234": {State: define.ContainerStateRunning, PID: 42},
+				"5678": {State: define.ContainerStateExited, PID: 666},
+				"9abc": {State: define.ContainerStatePaused, PID: 43},
+			},
+			map[string]string{"abcd": "pod"})
+
+		cntrs := Successful(Snapshot(storageroot))
+		Expect(cntrs).To(HaveLen(3))
+		Expect(*cntrs[0]).To(And(
+			HaveField("ID", "9abc"),
+			HaveField("PID", 43),
+			HaveField("Paused", true),
+			HaveField("Labels", And(
+				HaveKeyWithValue(StateLabelName, "paused"),
+				HaveKeyWithValue(PodIDName, "abcd"),
+				HaveKeyWithValue(PodLabelName, "pod"),
+				HaveKey(InfraLabelName),
+			)),
+		))
+		Expect(*cntrs[1]).To(And(
+			HaveField("ID", "5678"),
+			HaveField("PID", 0),
+			HaveField("Labels", And(
+				HaveKeyWithValue(StateLabelName, "exited"),
+				HaveKeyWithValue(PodLabelName, "pod"),
+				Not(HaveKey(InfraLabelName)),
+			)),
+		))
+		Expect(*cntrs[2]).To(And(
+			HaveField("ID", "1234"),
+			HaveField("Name", "foo"),
+			HaveField("PID", 42),
+			HaveField("Project", "project"),
+			HaveField("Labels", And(
+				HaveKeyWithValue(StateLabelName, "running"),
+				HaveKey(moby.PrivilegedLabel),
+				Not(HaveKey(PodIDName)),
+			)),
+		))
+	})
+
+	It("reads containers from the libpod SQLite state database", func() {
+		cntrs := Successful(Snapshot("testdata/sqlite"))
+		Expect(cntrs).To(HaveLen(4))
+		Expect(*cntrs[0]).To(And(
+			HaveField("ID", "9abc"),
+			HaveField("PID", 43),
+			HaveField("Paused", true),
+			HaveField("Labels", And(
+				HaveKeyWithValue(StateLabelName, "paused"),
+				HaveKeyWithValue(PodIDName, "abcd"),
+				HaveKeyWithValue(PodLabelName, "pod"),
+				HaveKey(InfraLabelName),
+			)),
+		))
+		Expect(*cntrs[1]).To(And(
+			HaveField("ID", "5678"),
+			HaveField("PID", 0),
+			HaveField("Labels", And(
+				HaveKeyWithValue(StateLabelName, "exited"),
+				HaveKeyWithValue(PodLabelName, "pod"),
+				Not(HaveKey(InfraLabelName)),
+			)),
+		))
+		Expect(*cntrs[2]).To(And(
+			HaveField("ID", "def0"),
+			HaveField("Name", "baz"),
+			HaveField("Labels", HaveKeyWithValue(StateLabelName, "unknown")),
+		))
+		Expect(*cntrs[3]).To(And(
+			HaveField("ID", "1234"),
+			HaveField("Name", "foo"),
+			HaveField("PID", 42),
+			HaveField("Project", "project"),
+			HaveField("Labels", And(
+				HaveKeyWithValue(StateLabelName, "running"),
+				HaveKey(moby.PrivilegedLabel),
+				Not(HaveKey(PodIDName)),
+			)),
+		))
+	})
+
+	It("falls back to containers/storage", func() {
+		cntrs := Successful(Snapshot("testdata/storage"))
+		Expect(cntrs).To(HaveLen(2))
+		Expect(*cntrs[0]).To(And(
+			HaveField("ID", "5678"),
+			HaveField("Name", "bar"),
+			HaveField("Labels", And(
+				HaveLen(2),
+				HaveKeyWithValue(StateLabelName, "unknown"),
+				HaveKeyWithValue(ImageIDLabelName, "deadbeef"),
+			)),
+		))
+		Expect(*cntrs[1]).To(And(
+			HaveField("ID", "1234"),
+			HaveField("Name", "foobar"),
+			HaveField("Labels", And(
+				HaveLen(3),
+				HaveKeyWithValue(StateLabelName, "unknown"),
+				HaveKeyWithValue(ImageNameLabelName, "docker.io/library/busybox:latest"),
+				HaveKeyWithValue(ImageIDLabelName, "deadbeef"),
+			)),
+		))
+	})
+
+	It("reports invalid containers/storage state", func() {
+		Expect(os.MkdirAll(filepath.Join(storageroot, "overlay-containers"), 0700)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(storageroot, "overlay-containers", "containers.json"),
+			[]byte("[{"), 0600)).To(Succeed())
+		Expect(Snapshot(storageroot)).Error().To(HaveOccurred())
+	})
+
+	It("reports invalid state", func() {
+		writeBoltState(storageroot, map[string]libpodContainerConfig{"1234": {ID: "1234"}}, nil, nil)
+		db := Successful(bolt.Open(filepath.Join(storageroot, boltStateDB), 0600, nil))
+		Expect(db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte(boltCtrBucket)).Bucket([]byte("1234")).
+				Put([]byte(boltConfigKey), []byte("{"))
+		})).To(Succeed())
+		Expect(db.Close()).To(Succeed())
+		Expect(Snapshot(storageroot)).Error().To(HaveOccurred())
+	})
+
+})
@@ -0,0 +1,134 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// socketRecheckInterval is the interval for rechecking a socket that exists
+// but isn't reachable (yet), as there won't be any filesystem events when the
+// service starts listening on an already existing socket.
+const socketRecheckInterval = 100 * time.Millisecond
+
+// WithSocketWaiting makes lazy connections (see [WithLazyConnection]) wait for
+// the Podman API socket to appear and become reachable, using
+// [WaitForSocket], instead of failing and leaving it to the watcher's backoff
+// to retry later. The watcher then becomes active as soon as the Podman API
+// service appears.
+func WithSocketWaiting() NewOption {
+	return func(pw *PodmanWatcher) {
+		pw.waitsocket = true
+	}
+}
+
+// WaitForSocket waits for the unix socket specified by the URI to appear and
+// to become reachable, or for the context to get cancelled. An empty URI is
+// [DefaultSocket]. WaitForSocket returns an error if the URI doesn't specify a
+// unix socket.
+//
+// Instead of polling, WaitForSocket watches the socket's parent directory for
+// changes, using inotify. If the parent directory doesn't exist yet, such as
+// /run/user/[UID] before the user logs in, WaitForSocket watches the nearest
+// existing ancestor directory instead, descending as directories get created.
+func WaitForSocket(ctx context.Context, podmansock string) error {
+	sockpath, ok, err := unixSocketPath(podmansock)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("not a unix socket URI: " + podmansock)
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	watched := ""
+	for {
+		// (Re)watch the nearest existing ancestor directory of the socket, as
+		// directories might have been created or removed in the meantime.
+		// As directories might also get created while we're adding a watch,
+		// repeat until the nearest existing ancestor doesn't change anymore.
+		// Only then check the socket, so we don't miss its creation.
+		for {
+			dir := existingAncestor(filepath.Dir(sockpath))
+			if dir == watched {
+				break
+			}
+			if watched != "" {
+				_ = watcher.Remove(watched) // might have gone already.
+			}
+			if err := watcher.Add(dir); err != nil {
+				return err
+			}
+			watched = dir
+		}
+		var recheck <-chan time.Time
+		switch info, err := os.Stat(sockpath); {
+		case err != nil:
+		case info.Mode()&os.ModeSocket == 0:
+			// Not a socket (yet?), so wait for it to get replaced.
+		case isReachable(ctx, sockpath):
+			return nil
+		default:
+			recheck = time.After(socketRecheckInterval)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return errors.New("socket watcher terminated")
+			}
+			return err
+		case <-watcher.Events:
+		case <-recheck:
+		}
+	}
+}
+
+// existingAncestor returns the specified directory if it exists, otherwise
+// its nearest existing ancestor directory.
+func existingAncestor(dir string) string {
+	for {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// isReachable returns true if the unix socket at the specified path accepts
+// connections.
+func isReachable(ctx context.Context, sockpath string) bool {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "unix", sockpath)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
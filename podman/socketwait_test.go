@@ -0,0 +1,120 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podman
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/thediveo/success"
+)
+
+var _ = Describe("waiting for sockets", func() {
+
+	It("finds existing ancestor directories", func() {
+		tmpdir := GinkgoT().TempDir()
+		Expect(existingAncestor(tmpdir)).To(Equal(tmpdir))
+		Expect(existingAncestor(filepath.Join(tmpdir, "foo", "bar"))).To(Equal(tmpdir))
+		Expect(existingAncestor("/")).To(Equal("/"))
+	})
+
+	It("rejects non-unix URIs", func(ctx context.Context) {
+		Expect(WaitForSocket(ctx, "tcp://localhost:1234")).To(
+			MatchError(ContainSubstring("not a unix socket URI")))
+		Expect(WaitForSocket(ctx, "unix:///bourish\x7f")).To(HaveOccurred())
+	})
+
+	It("returns immediately for reachable sockets", func(ctx context.Context) {
+		sockpath := filepath.Join(GinkgoT().TempDir(), "podman.sock")
+		l := Successful(net.Listen("unix", sockpath))
+		defer l.Close()
+		Expect(WaitForSocket(ctx, "unix://"+sockpath)).To(Succeed())
+	})
+
+	It("waits for sockets in yet non-existing directories", func(ctx context.Context) {
+		tmpdir := GinkgoT().TempDir()
+		sockpath := filepath.Join(tmpdir, "user", "1000", "podman", "podman.sock")
+		done := make(chan error)
+		go func() { done <- WaitForSocket(ctx, "unix://"+sockpath) }()
+
+		Consistently(done).WithTimeout(200 * time.Millisecond).ShouldNot(Receive())
+		Expect(os.MkdirAll(filepath.Join(tmpdir, "user", "1000"), 0755)).To(Succeed())
+		Consistently(done).WithTimeout(200 * time.Millisecond).ShouldNot(Receive())
+		Expect(os.Mkdir(filepath.Dir(sockpath), 0755)).To(Succeed())
+		l := Successful(net.Listen("unix", sockpath))
+		defer l.Close()
+		Eventually(done).Within(time.Second).Should(Receive(BeNil()))
+	})
+
+	It("waits for stale sockets to become reachable", func(ctx context.Context) {
+		sockpath := filepath.Join(GinkgoT().TempDir(), "podman.sock")
+		l := Successful(net.ListenUnix("unix", &net.UnixAddr{Name: sockpath, Net: "unix"}))
+		l.SetUnlinkOnClose(false)
+		Expect(l.Close()).To(Succeed())
+		done := make(chan error)
+		go func() { done <- WaitForSocket(ctx, "unix://"+sockpath) }()
+
+		Consistently(done).WithTimeout(3 * socketRecheckInterval).ShouldNot(Receive())
+		Expect(os.Remove(sockpath)).To(Succeed())
+		l = Successful(net.ListenUnix("unix", &net.UnixAddr{Name: sockpath, Net: "unix"}))
+		defer l.Close()
+		Eventually(done).Within(time.Second).Should(Receive(BeNil()))
+	})
+
+	It("stops waiting when cancelled", func(ctx context.Context) {
+		ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		Expect(WaitForSocket(ctx, "unix://"+filepath.Join(GinkgoT().TempDir(), "podman.sock"))).To(
+			MatchError(context.DeadlineExceeded))
+	})
+
+	It("lazily connects as soon as the socket appears", func(ctx context.Context) {
+		sockpath := filepath.Join(GinkgoT().TempDir(), "run", "podman.sock")
+		pw := NewPodmanWatcher(nil, WithLazyConnection("unix://"+sockpath), WithSocketWaiting())
+		defer pw.Close()
+		Expect(pw.waitsocket).To(BeTrue())
+		done := make(chan error)
+		go func() { done <- pw.connected(ctx) }()
+
+		Consistently(done).WithTimeout(200 * time.Millisecond).ShouldNot(Receive())
+		Expect(os.Mkdir(filepath.Dir(sockpath), 0755)).To(Succeed())
+		serveFakePodman(sockpath)
+		Eventually(done).Within(time.Second).Should(Receive(BeNil()))
+		Expect(pw.Client()).NotTo(BeNil())
+	})
+
+	It("honors each caller's context while waiting", func(ctx context.Context) {
+		sockpath := filepath.Join(GinkgoT().TempDir(), "podman.sock")
+		pw := NewPodmanWatcher(nil, WithLazyConnection("unix://"+sockpath), WithSocketWaiting())
+		defer pw.Close()
+		waitctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		done := make(chan error)
+		go func() { done <- pw.connected(waitctx) }()
+		Consistently(done).WithTimeout(100 * time.Millisecond).ShouldNot(Receive())
+
+		tryctx, trycancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer trycancel()
+		Expect(pw.connected(tryctx)).To(MatchError(context.DeadlineExceeded))
+		cancel()
+		Eventually(done).Within(time.Second).Should(Receive(MatchError(context.Canceled)))
+	})
+
+})
@@ -22,6 +22,7 @@ import (
 	"github.com/containers/podman/v4/pkg/bindings"
 	"github.com/containers/podman/v4/pkg/rootless"
 	"github.com/thediveo/sealwatcher/v2/test"
+	"github.com/thediveo/sealwatcher/v2/util"
 	"github.com/thediveo/whalewatcher"
 	"github.com/thediveo/whalewatcher/engineclient/moby"
 
@@ -53,7 +54,9 @@ var _ = Describe("podman watcher", func() {
 	})
 
 	It("reports errors", func() {
-		Expect(New("unix:///bourish.socket.puppet", nil)).Error().To(HaveOccurred())
+		Expect(New("unix:///bourish.socket.puppet", nil)).Error().To(
+			MatchError(util.ErrServiceUnavailable))
+		Expect(NewForPID(os.Getpid(), "unix:///bourish.socket.puppet", nil)).Error().To(HaveOccurred())
 	})
 
 	It("watches a container", func(ctx context.Context) {
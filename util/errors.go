@@ -0,0 +1,148 @@
+// Copyright 2023 Harald Albrecht.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/containers/podman/v4/pkg/errorhandling"
+)
+
+// Kinds of Podman-related errors, as returned by the sealwatcher. Use
+// [errors.Is] or the corresponding classifiers, such as [IsNoSuchPodErr], to
+// check for a specific kind of error; the classifiers additionally recognize
+// errors returned by the Podman REST API client directly.
+var (
+	ErrNoSuchContainer    = errors.New("no such container")
+	ErrNoSuchPod          = errors.New("no such pod")
+	ErrServiceUnavailable = errors.New("podman service unavailable")
+	ErrPermissionDenied   = errors.New("permission denied on Podman service")
+	ErrAPIVersion         = errors.New("podman API version mismatch")
+	ErrServiceTimeout     = errors.New("podman service timeout")
+	ErrInternal           = errors.New("podman engine internal error")
+)
+
+// Error is a Podman-related error of a specific kind, such as [ErrNoSuchPod],
+// wrapping the original error.
+type Error struct {
+	Kind error // the kind of error, such as ErrNoSuchPod.
+	Err  error // the original error.
+}
+
+// Error returns the original error's message.
+func (e *Error) Error() string { return e.Err.Error() }
+
+// Unwrap returns the original error.
+func (e *Error) Unwrap() error { return e.Err }
+
+// Is returns true if the target is this error's kind.
+func (e *Error) Is(target error) bool { return target == e.Kind }
+
+// Classify returns the specified error wrapped in an [Error] of the error's
+// kind. If the error's kind cannot be determined or if the error has already
+// been classified, then Classify returns the error unchanged.
+func Classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	var classified *Error
+	if errors.As(err, &classified) {
+		return err
+	}
+	kind := kindOf(err)
+	if kind == nil {
+		return err
+	}
+	return &Error{Kind: kind, Err: err}
+}
+
+// IsNoSuchPodErr returns true if the given error is a 404 error response and
+// the cause is "no such pod".
+func IsNoSuchPodErr(err error) bool { return is(err, ErrNoSuchPod) }
+
+// IsServiceUnavailableErr returns true if the given error is due to the Podman
+// service refusing connections or its socket missing. Retrying later might
+// succeed.
+func IsServiceUnavailableErr(err error) bool { return is(err, ErrServiceUnavailable) }
+
+// IsPermissionDeniedErr returns true if the given error is due to missing
+// permissions to connect to the Podman service's socket. Retrying usually
+// doesn't help.
+func IsPermissionDeniedErr(err error) bool { return is(err, ErrPermissionDenied) }
+
+// IsAPIVersionErr returns true if the given error is due to the Podman
+// service's API version being too old. Retrying doesn't help.
+func IsAPIVersionErr(err error) bool { return is(err, ErrAPIVersion) }
+
+// IsServiceTimeoutErr returns true if the given error is due to the Podman
+// service not responding in time. Retrying later might succeed.
+func IsServiceTimeoutErr(err error) bool { return is(err, ErrServiceTimeout) }
+
+// IsInternalErr returns true if the given error is a 5xx error response, that
+// is, an engine-side internal error.
+func IsInternalErr(err error) bool { return is(err, ErrInternal) }
+
+// is returns true if the specified error either has already been classified
+// as the specified kind of error, or otherwise is of this kind.
+func is(err error, kind error) bool {
+	if err == nil {
+		return false
+	}
+	return errors.Is(err, kind) || kindOf(err) == kind
+}
+
+// kindOf returns the kind of the specified error, or nil if unknown.
+func kindOf(err error) error {
+	var em *errorhandling.ErrorModel
+	if errors.As(err, &em) {
+		switch {
+		case em.ResponseCode == http.StatusNotFound && em.Because == "no such container":
+			return ErrNoSuchContainer
+		case em.ResponseCode == http.StatusNotFound && em.Because == "no such pod":
+			return ErrNoSuchPod
+		case em.ResponseCode >= http.StatusInternalServerError:
+			return ErrInternal
+		}
+		return nil
+	}
+	// The Podman REST API client doesn't use a dedicated error type for API
+	// version mismatches, so we need to resort to the error message.
+	if strings.Contains(err.Error(), "API version is too old") {
+		return ErrAPIVersion
+	}
+	var operr *net.OpError
+	if errors.As(err, &operr) {
+		switch {
+		case errors.Is(operr, syscall.ECONNREFUSED), errors.Is(operr, syscall.ENOENT):
+			return ErrServiceUnavailable
+		case errors.Is(operr, syscall.EACCES), errors.Is(operr, syscall.EPERM):
+			return ErrPermissionDenied
+		}
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) {
+		return ErrServiceTimeout
+	}
+	var neterr net.Error
+	if errors.As(err, &neterr) && neterr.Timeout() {
+		return ErrServiceTimeout
+	}
+	return nil
+}
@@ -18,18 +18,6 @@ client.
 */
 package util
 
-import (
-	"net/http"
-
-	"github.com/containers/podman/v4/pkg/errorhandling"
-)
-
 // IsNoSuchContainerErr returns true if the given error is a 404 error response
 // and the cause is "no such container".
-func IsNoSuchContainerErr(err error) bool {
-	em, ok := err.(*errorhandling.ErrorModel)
-	if !ok {
-		return false
-	}
-	return (em.ResponseCode == http.StatusNotFound) && (em.Because == "no such container")
-}
+func IsNoSuchContainerErr(err error) bool { return is(err, ErrNoSuchContainer) }
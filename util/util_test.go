@@ -15,8 +15,13 @@
 package util
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"syscall"
 
 	"github.com/containers/podman/v4/pkg/errorhandling"
 	. "github.com/onsi/ginkgo/v2"
@@ -53,4 +58,81 @@ var _ = Describe("util", Ordered, func() {
 
 	})
 
+	Context("error classification", func() {
+
+		dial := func(sockpath string) error {
+			_, err := net.Dial("unix", sockpath)
+			return err
+		}
+
+		It("doesn't classify nil, unknown, or cancellation errors", func() {
+			Expect(Classify(nil)).To(BeNil())
+			err := errors.New("42")
+			Expect(Classify(err)).To(BeIdenticalTo(err))
+			Expect(Classify(context.Canceled)).To(BeIdenticalTo(context.Canceled))
+			err = &errorhandling.ErrorModel{ResponseCode: http.StatusConflict}
+			Expect(Classify(err)).To(BeIdenticalTo(err))
+		})
+
+		It("classifies only once", func() {
+			err := Classify(&errorhandling.ErrorModel{ResponseCode: http.StatusInternalServerError})
+			Expect(Classify(err)).To(BeIdenticalTo(err))
+			Expect(Classify(fmt.Errorf("wrapped: %w", err))).To(MatchError(ErrInternal))
+		})
+
+		It("keeps the original error", func() {
+			em := &errorhandling.ErrorModel{
+				ResponseCode: http.StatusNotFound,
+				Because:      "no such pod",
+				Message:      "no such pod: foobar",
+			}
+			err := Classify(em)
+			Expect(err).To(MatchError(ErrNoSuchPod))
+			Expect(err.Error()).To(Equal(em.Error()))
+			var target *errorhandling.ErrorModel
+			Expect(errors.As(err, &target)).To(BeTrue())
+			Expect(target).To(BeIdenticalTo(em))
+		})
+
+		DescribeTable("classifies errors",
+			func(err error, kind error, classifier func(error) bool) {
+				Expect(Classify(err)).To(MatchError(kind))
+				Expect(classifier(err)).To(BeTrue())
+				Expect(classifier(Classify(err))).To(BeTrue())
+				Expect(classifier(fmt.Errorf("wrapped: %w", err))).To(BeTrue())
+				Expect(classifier(nil)).To(BeFalse())
+				Expect(classifier(errors.New("42"))).To(BeFalse())
+			},
+			Entry("no such container",
+				&errorhandling.ErrorModel{ResponseCode: http.StatusNotFound, Because: "no such container"},
+				ErrNoSuchContainer, IsNoSuchContainerErr),
+			Entry("no such pod",
+				&errorhandling.ErrorModel{ResponseCode: http.StatusNotFound, Because: "no such pod"},
+				ErrNoSuchPod, IsNoSuchPodErr),
+			Entry("internal error",
+				&errorhandling.ErrorModel{ResponseCode: http.StatusInternalServerError, Because: "D'OH!"},
+				ErrInternal, IsInternalErr),
+			Entry("missing socket",
+				dial("/bourish.socket.puppet"),
+				ErrServiceUnavailable, IsServiceUnavailableErr),
+			Entry("connection refused",
+				&net.OpError{Op: "dial", Net: "unix", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)},
+				ErrServiceUnavailable, IsServiceUnavailableErr),
+			Entry("permission denied",
+				&net.OpError{Op: "dial", Net: "unix", Err: os.NewSyscallError("connect", syscall.EACCES)},
+				ErrPermissionDenied, IsPermissionDeniedErr),
+			Entry("API version mismatch",
+				fmt.Errorf("unable to connect to Podman socket: %w",
+					errors.New(`server API version is too old. Client "4.0.0" server "3.4.4"`)),
+				ErrAPIVersion, IsAPIVersionErr),
+			Entry("deadline exceeded",
+				context.DeadlineExceeded,
+				ErrServiceTimeout, IsServiceTimeoutErr),
+			Entry("network timeout",
+				&net.OpError{Op: "read", Net: "unix", Err: os.ErrDeadlineExceeded},
+				ErrServiceTimeout, IsServiceTimeoutErr),
+		)
+
+	})
+
 })